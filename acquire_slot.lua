@@ -0,0 +1,28 @@
+-- KEYS:
+--  [1] key of the semaphore (sorted set of JIDs scored by lease expiration)
+-- ARGUMENTS:
+--  [1] JID acquiring a slot
+--  [2] Number of the slots
+--  [3] Lease time (in seconds)
+--  [4] Current timestamp (in ms)
+--
+--  Return values:
+--    1  if the slot is acquired (or its lease is extended)
+--    0  if all the slots are taken
+
+local nowMs = tonumber(ARGV[4])
+local leaseMs = tonumber(ARGV[3]) * 1000
+
+-- Release the slots whose leases expired
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", nowMs)
+
+if redis.call("ZSCORE", KEYS[1], ARGV[1]) == false and
+    redis.call("ZCARD", KEYS[1]) >= tonumber(ARGV[2]) then
+  return 0
+end
+
+redis.call("ZADD", KEYS[1], nowMs + leaseMs, ARGV[1])
+-- The semaphore is gone once the last lease expires
+local lastMs = redis.call("ZRANGE", KEYS[1], -1, -1, "WITHSCORES")[2]
+redis.call("PEXPIREAT", KEYS[1], lastMs)
+return 1
@@ -0,0 +1,211 @@
+package admin
+
+import (
+	"html/template"
+	"net/http"
+	"net/url"
+	"time"
+
+	once "github.com/PlanitarInc/go-workers-once"
+)
+
+var templateFuncs = template.FuncMap{
+	"time": func(ms int64) string {
+		if ms == 0 {
+			return "-"
+		}
+		return time.Unix(ms/1000, (ms%1000)*1e6).UTC().Format(time.RFC3339)
+	},
+	// jobQuery returns the query identifying the job
+	"jobQuery": func(desc *once.JobDesc) template.URL {
+		q := url.Values{"queue": {desc.Queue}, "type": {desc.JobType}}
+		if desc.UniqueKey != "" {
+			q.Set("unique_key", desc.UniqueKey)
+		}
+		return template.URL(q.Encode())
+	},
+}
+
+var layout = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>once-jobs</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.6em; text-align: left; }
+form.inline { display: inline-block; margin-right: 1em; }
+.error { color: #c00; }
+</style>
+</head>
+<body>
+<h1><a href="./">once-jobs</a></h1>
+{{template "content" .}}
+</body>
+</html>
+`
+
+var indexTemplate = template.Must(template.Must(
+	template.New("index").Funcs(templateFuncs).Parse(layout)).Parse(`
+{{define "content"}}
+<form method="get" action="./">
+  <input name="queue" placeholder="queue" value="{{.Filter.Queue}}">
+  <input name="type" placeholder="job type prefix"
+    value="{{.Filter.JobTypePrefix}}">
+  <input name="status" placeholder="statuses, e.g. executing"
+    value="{{.Status}}">
+  <input name="min_age" placeholder="min age, e.g. 1h" value="{{.MinAge}}">
+  <button type="submit">Filter</button>
+</form>
+{{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+<table>
+  <tr>
+    <th>JID</th><th>Queue</th><th>Job type</th><th>Status</th>
+    <th>Updated</th><th>Result</th>
+  </tr>
+  {{range .Page.Jobs}}
+  <tr>
+    <td><a href="job?{{jobQuery .}}">{{.Jid}}</a></td>
+    <td>{{.Queue}}</td>
+    <td>{{.JobType}}{{if .UniqueKey}}:{{.UniqueKey}}{{end}}</td>
+    <td>{{.Status}}</td>
+    <td>{{time .UpdatedMs}}</td>
+    <td>{{.Result}}</td>
+  </tr>
+  {{end}}
+</table>
+{{if .NextPage}}<p><a href="?{{.NextPage}}">Next page</a></p>{{end}}
+{{end}}
+`))
+
+var jobTemplate = template.Must(template.Must(
+	template.New("job").Funcs(templateFuncs).Parse(layout)).Parse(`
+{{define "content"}}
+{{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+{{with .Desc}}
+<h2>{{.Queue}} / {{.JobType}}</h2>
+<table>
+  <tr><th>JID</th><td>{{.Jid}}</td></tr>
+  {{if .UniqueKey}}<tr><th>Unique key</th><td>{{.UniqueKey}}</td></tr>{{end}}
+  <tr><th>Status</th><td>{{.Status}}</td></tr>
+  <tr><th>Created</th><td>{{time .CreatedMs}}</td></tr>
+  <tr><th>Updated</th><td>{{time .UpdatedMs}}</td></tr>
+  {{if .RunAtMs}}<tr><th>Runs at</th><td>{{time .RunAtMs}}</td></tr>{{end}}
+  {{if .ProgressMessage}}
+  <tr><th>Progress</th><td>{{.Progress}} {{.ProgressMessage}}</td></tr>
+  {{end}}
+  <tr><th>Result</th><td>{{.Result}}</td></tr>
+</table>
+
+<h3>Attempts</h3>
+<table>
+  <tr>
+    <th>Started</th><th>Ended</th><th>Outcome</th><th>Error</th>
+    <th>Worker</th>
+  </tr>
+  {{range .Attempts}}
+  <tr>
+    <td>{{time .StartMs}}</td>
+    <td>{{time .EndMs}}</td>
+    <td>{{.Outcome}}</td>
+    <td>{{.Error}}</td>
+    <td>{{.Worker}}</td>
+  </tr>
+  {{end}}
+</table>
+
+<h3>Actions</h3>
+{{if not .IsDone}}
+<form class="inline" method="post" action="api/cancel">
+  <input type="hidden" name="queue" value="{{.Queue}}">
+  <input type="hidden" name="type" value="{{.JobType}}">
+  <input type="hidden" name="jid" value="{{.Jid}}">
+  {{if .UniqueKey}}<input name="args" placeholder="args (JSON)">{{end}}
+  <input type="hidden" name="redirect" value="../job?{{jobQuery .}}">
+  <button type="submit">Cancel</button>
+</form>
+{{end}}
+<form class="inline" method="post" action="api/force">
+  <input type="hidden" name="queue" value="{{.Queue}}">
+  <input type="hidden" name="type" value="{{.JobType}}">
+  {{if .UniqueKey}}<input name="args" placeholder="args (JSON)">{{end}}
+  <input type="hidden" name="redirect" value="../job?{{jobQuery .}}">
+  <button type="submit">Force re-enqueue</button>
+</form>
+{{end}}
+{{end}}
+`))
+
+func (h *Handler) serveIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	data := struct {
+		Filter   once.ListFilter
+		Status   string
+		MinAge   string
+		Page     *once.ListPage
+		NextPage template.URL
+		Error    error
+	}{
+		Status: r.URL.Query().Get("status"),
+		MinAge: r.URL.Query().Get("min_age"),
+		Page:   &once.ListPage{},
+	}
+
+	status := http.StatusOK
+	filter, err := listFilter(r)
+	if err == nil {
+		data.Page, err = h.backend.List(r.Context(), filter)
+	}
+	if err != nil {
+		status = errorStatus(err)
+		data.Page = &once.ListPage{}
+		data.Error = err
+	}
+	data.Filter = filter
+
+	if data.Page.Cursor != "" {
+		q := r.URL.Query()
+		q.Set("cursor", data.Page.Cursor)
+		data.NextPage = template.URL(q.Encode())
+	}
+
+	renderHtml(w, status, indexTemplate, data)
+}
+
+func (h *Handler) serveJobPage(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	data := struct {
+		Desc  *once.JobDesc
+		Error error
+	}{}
+
+	status := http.StatusOK
+	data.Desc, data.Error = h.getDesc(r)
+	if data.Error != nil {
+		status = errorStatus(data.Error)
+	}
+
+	renderHtml(w, status, jobTemplate, data)
+}
+
+func renderHtml(
+	w http.ResponseWriter,
+	status int,
+	tmpl *template.Template,
+	data interface{},
+) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	tmpl.Execute(w, data)
+}
@@ -0,0 +1,429 @@
+// Package admin serves the once-jobs over HTTP: a JSON API and a small HTML
+// dashboard listing the jobs, showing their details and attempts, and
+// cancelling or force-enqueueing them.
+//
+// The handler uses relative links only, so it can be mounted under any
+// prefix:
+//
+//	mux.Handle("/admin/once/", http.StripPrefix("/admin/once",
+//		admin.NewHandler(nil)))
+//
+// The JSON API is:
+//
+//	GET  api/jobs    list the jobs: queue, type (prefix), status (comma
+//	                 separated), min_age, max_age, cursor and limit
+//	GET  api/job     get the job: queue, type and either args (JSON) or
+//	                 unique_key
+//	POST api/cancel  cancel the job: queue, type, jid and args
+//	POST api/force   enqueue the job replacing the current one: queue, type,
+//	                 args and options (JSON, the current job's by default)
+//
+// The POST parameters are form values. If a redirect value is given (a
+// dashboard page), the response redirects there instead, which is how the
+// dashboard forms work.
+// The cross-site POST requests are rejected, see sameOrigin.
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	once "github.com/PlanitarInc/go-workers-once"
+)
+
+// backend is the part of once.Client used by the handler.
+type backend interface {
+	List(ctx context.Context, filter once.ListFilter) (*once.ListPage, error)
+	GetDescContext(
+		ctx context.Context,
+		queue, jobType string,
+		args ...interface{},
+	) (*once.JobDesc, error)
+	CancelContext(
+		ctx context.Context,
+		queue, jobType string,
+		options ...once.CancelOptions,
+	) error
+	EnqueueForceContext(
+		ctx context.Context,
+		queue, jobType string,
+		args interface{},
+		opts *once.Options,
+	) (string, error)
+}
+
+// defaultBackend uses the package-level functions of once.
+type defaultBackend struct{}
+
+func (defaultBackend) List(
+	ctx context.Context,
+	filter once.ListFilter,
+) (*once.ListPage, error) {
+	return once.List(ctx, filter)
+}
+
+func (defaultBackend) GetDescContext(
+	ctx context.Context,
+	queue, jobType string,
+	args ...interface{},
+) (*once.JobDesc, error) {
+	return once.GetDescContext(ctx, queue, jobType, args...)
+}
+
+func (defaultBackend) CancelContext(
+	ctx context.Context,
+	queue, jobType string,
+	options ...once.CancelOptions,
+) error {
+	return once.CancelContext(ctx, queue, jobType, options...)
+}
+
+func (defaultBackend) EnqueueForceContext(
+	ctx context.Context,
+	queue, jobType string,
+	args interface{},
+	opts *once.Options,
+) (string, error) {
+	return once.EnqueueForceContext(ctx, queue, jobType, args, opts)
+}
+
+var badRequestErr = errors.New("bad request")
+
+// Handler serves the once-jobs of a client.
+type Handler struct {
+	backend backend
+	mux     *http.ServeMux
+}
+
+// NewHandler returns a handler serving the jobs of the given client, or of
+// the package-level functions of once if nil.
+func NewHandler(client *once.Client) *Handler {
+	h := &Handler{backend: defaultBackend{}}
+	if client != nil {
+		h.backend = client
+	}
+
+	h.mux = http.NewServeMux()
+	h.mux.HandleFunc("/", h.serveIndex)
+	h.mux.HandleFunc("/job", h.serveJobPage)
+	h.mux.HandleFunc("/api/jobs", h.serveJobs)
+	h.mux.HandleFunc("/api/job", h.serveJob)
+	h.mux.HandleFunc("/api/cancel", h.serveCancel)
+	h.mux.HandleFunc("/api/force", h.serveForce)
+
+	return h
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Mounted with http.StripPrefix, the root might be left empty
+	if r.URL.Path == "" {
+		r.URL.Path = "/"
+	}
+	h.mux.ServeHTTP(w, r)
+}
+
+func (h *Handler) serveJobs(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	filter, err := listFilter(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	page, err := h.backend.List(r.Context(), filter)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJson(w, http.StatusOK, page)
+}
+
+func (h *Handler) serveJob(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	desc, err := h.getDesc(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJson(w, http.StatusOK, desc)
+}
+
+func (h *Handler) serveCancel(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) || !sameOrigin(w, r) {
+		return
+	}
+
+	queue, jobType, args, err := jobParams(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	location, err := redirectLocation(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	err = h.backend.CancelContext(r.Context(), queue, jobType,
+		once.CancelOptions{Jid: r.FormValue("jid"), Args: args})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if !redirect(w, location) {
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (h *Handler) serveForce(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) || !sameOrigin(w, r) {
+		return
+	}
+
+	queue, jobType, args, err := jobParams(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	location, err := redirectLocation(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	var opts *once.Options
+	if val := r.FormValue("options"); val != "" {
+		opts = &once.Options{}
+		if err := json.Unmarshal([]byte(val), opts); err != nil {
+			writeError(w, badRequest("invalid options: %v", err))
+			return
+		}
+	} else {
+		// Keep the options of the current job
+		desc, err := h.backend.GetDescContext(r.Context(), queue, jobType,
+			argsList(args)...)
+		if err != nil && err != once.NoMatchingJobsErr {
+			writeError(w, err)
+			return
+		}
+		if desc != nil {
+			opts = desc.Options
+		}
+	}
+	if opts != nil && opts.UniqueByArgs && args == nil {
+		writeError(w, badRequest("args are required"))
+		return
+	}
+
+	jid, err := h.backend.EnqueueForceContext(r.Context(), queue, jobType,
+		args, opts)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if !redirect(w, location) {
+		writeJson(w, http.StatusOK, map[string]string{"jid": jid})
+	}
+}
+
+// getDesc returns the descriptor of the job identified either by the args
+// or by the unique key, as listed.
+func (h *Handler) getDesc(r *http.Request) (*once.JobDesc, error) {
+	queue, jobType, args, err := jobParams(r)
+	if err != nil {
+		return nil, err
+	}
+
+	uniqueKey := r.FormValue("unique_key")
+	if uniqueKey == "" {
+		return h.backend.GetDescContext(r.Context(), queue, jobType,
+			argsList(args)...)
+	}
+
+	filter := once.ListFilter{Queue: queue, JobTypePrefix: jobType}
+	for {
+		page, err := h.backend.List(r.Context(), filter)
+		if err != nil {
+			return nil, err
+		}
+		for _, desc := range page.Jobs {
+			if desc.JobType == jobType && desc.UniqueKey == uniqueKey {
+				return desc, nil
+			}
+		}
+		if page.Cursor == "" {
+			return nil, once.NoMatchingJobsErr
+		}
+		filter.Cursor = page.Cursor
+	}
+}
+
+// listFilter reads the ListFilter from the query.
+func listFilter(r *http.Request) (once.ListFilter, error) {
+	q := r.URL.Query()
+	filter := once.ListFilter{
+		Queue:         q.Get("queue"),
+		JobTypePrefix: q.Get("type"),
+		Cursor:        q.Get("cursor"),
+	}
+
+	if val := q.Get("status"); val != "" {
+		filter.Statuses = strings.Split(val, ",")
+	}
+
+	var err error
+	if val := q.Get("min_age"); val != "" {
+		if filter.MinAge, err = time.ParseDuration(val); err != nil {
+			return filter, badRequest("invalid min_age: %v", err)
+		}
+	}
+	if val := q.Get("max_age"); val != "" {
+		if filter.MaxAge, err = time.ParseDuration(val); err != nil {
+			return filter, badRequest("invalid max_age: %v", err)
+		}
+	}
+	if val := q.Get("limit"); val != "" {
+		if filter.Limit, err = strconv.Atoi(val); err != nil {
+			return filter, badRequest("invalid limit: %v", err)
+		}
+	}
+
+	return filter, nil
+}
+
+// jobParams reads the queue, the job type and the args (nil if not given)
+// from the query or the form.
+func jobParams(r *http.Request) (string, string, interface{}, error) {
+	queue, jobType := r.FormValue("queue"), r.FormValue("type")
+	if queue == "" || jobType == "" {
+		return "", "", nil, badRequest("queue and type are required")
+	}
+
+	var args interface{}
+	if val := r.FormValue("args"); val != "" {
+		if err := json.Unmarshal([]byte(val), &args); err != nil {
+			return "", "", nil, badRequest("invalid args: %v", err)
+		}
+	}
+
+	return queue, jobType, args, nil
+}
+
+func argsList(args interface{}) []interface{} {
+	if args == nil {
+		return nil
+	}
+	return []interface{}{args}
+}
+
+func allowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+
+	w.Header().Set("Allow", method)
+	writeJson(w, http.StatusMethodNotAllowed,
+		map[string]string{"error": "method not allowed"})
+	return false
+}
+
+// sameOrigin rejects the request sent by a browser from another site, e.g. a
+// form posted by a foreign page on behalf of the logged-in user. The browser
+// tells the site by Sec-Fetch-Site or, if older, by Origin. The requests
+// with neither, e.g. by curl, are allowed.
+func sameOrigin(w http.ResponseWriter, r *http.Request) bool {
+	allowed := true
+	if site := r.Header.Get("Sec-Fetch-Site"); site != "" {
+		allowed = site == "same-origin" || site == "none"
+	} else if origin := r.Header.Get("Origin"); origin != "" {
+		u, err := url.Parse(origin)
+		allowed = err == nil && u.Host == r.Host
+	}
+	if allowed {
+		return true
+	}
+
+	writeJson(w, http.StatusForbidden,
+		map[string]string{"error": "cross-site request"})
+	return false
+}
+
+// redirectPages are the dashboard pages the forms redirect back to, relative
+// to the api/ path.
+var redirectPages = map[string]bool{"../": true, "../job": true}
+
+// redirectLocation returns the redirect form value, if any. Only the
+// dashboard pages are allowed, so the handler cannot be used to redirect
+// elsewhere.
+func redirectLocation(r *http.Request) (string, error) {
+	location := r.FormValue("redirect")
+	if location == "" {
+		return "", nil
+	}
+
+	// The browsers take a backslash for a slash
+	u, err := url.Parse(location)
+	if err != nil || strings.Contains(location, `\`) ||
+		!redirectPages[u.Path] {
+		return "", badRequest("invalid redirect")
+	}
+
+	return location, nil
+}
+
+// redirect redirects to the location, if any. Unlike http.Redirect, it
+// leaves resolving the location to the browser: the request path might be
+// stripped of the prefix.
+func redirect(w http.ResponseWriter, location string) bool {
+	if location == "" {
+		return false
+	}
+
+	w.Header().Set("Location", location)
+	w.WriteHeader(http.StatusSeeOther)
+	return true
+}
+
+func badRequest(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", badRequestErr, fmt.Sprintf(format, args...))
+}
+
+func writeJson(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	writeJson(w, errorStatus(err), map[string]string{"error": err.Error()})
+}
+
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, badRequestErr):
+		return http.StatusBadRequest
+	case err == once.NoMatchingJobsErr:
+		return http.StatusNotFound
+	case err == once.AlreadyDoneErr, err == once.SupersededErr:
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
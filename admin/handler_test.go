@@ -0,0 +1,377 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/PlanitarInc/go-workers"
+	once "github.com/PlanitarInc/go-workers-once"
+	. "github.com/onsi/gomega"
+)
+
+// newTestServer serves the handler of a client keeping the jobs in memory,
+// under a prefix.
+func newTestServer() (*httptest.Server, *once.Client) {
+	client := &once.Client{
+		Store: once.NewMemoryStore(),
+		EnqueueMsg: func(ctx context.Context, msg *workers.Msg) error {
+			return nil
+		},
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/admin/once/", http.StripPrefix("/admin/once",
+		NewHandler(client)))
+
+	return httptest.NewServer(mux), client
+}
+
+func getJson(url string, v interface{}) int {
+	resp, err := http.Get(url)
+	Ω(err).Should(BeNil())
+	defer resp.Body.Close()
+
+	Ω(resp.Header.Get("Content-Type")).Should(Equal("application/json"))
+	Ω(json.NewDecoder(resp.Body).Decode(v)).Should(BeNil())
+	return resp.StatusCode
+}
+
+func TestHandler_Jobs(t *testing.T) {
+	RegisterTestingT(t)
+
+	srv, client := newTestServer()
+	defer srv.Close()
+
+	jid1, err := client.Enqueue("q1", "email", nil, nil)
+	Ω(err).Should(BeNil())
+	jid2, err := client.Enqueue("q1", "sms", nil, nil)
+	Ω(err).Should(BeNil())
+	_, err = client.Enqueue("q2", "email", nil, nil)
+	Ω(err).Should(BeNil())
+	Ω(client.Cancel("q1", "sms")).Should(BeNil())
+
+	{
+		page := once.ListPage{}
+		status := getJson(srv.URL+"/admin/once/api/jobs?queue=q1", &page)
+		Ω(status).Should(Equal(http.StatusOK))
+		Ω(page.Jobs).Should(HaveLen(2))
+		Ω(page.Cursor).Should(BeEmpty())
+	}
+
+	{
+		page := once.ListPage{}
+		status := getJson(srv.URL+
+			"/admin/once/api/jobs?queue=q1&status=init-waiting,executing",
+			&page)
+		Ω(status).Should(Equal(http.StatusOK))
+		Ω(page.Jobs).Should(HaveLen(1))
+		Ω(page.Jobs[0].Jid).Should(Equal(jid1))
+	}
+
+	{
+		page := once.ListPage{}
+		status := getJson(srv.URL+"/admin/once/api/jobs?limit=2", &page)
+		Ω(status).Should(Equal(http.StatusOK))
+		Ω(page.Jobs).Should(HaveLen(2))
+		Ω(page.Cursor).ShouldNot(BeEmpty())
+	}
+
+	{
+		res := map[string]string{}
+		status := getJson(srv.URL+"/admin/once/api/jobs?min_age=soon", &res)
+		Ω(status).Should(Equal(http.StatusBadRequest))
+		Ω(res["error"]).Should(ContainSubstring("min_age"))
+	}
+
+	{
+		desc := once.JobDesc{}
+		status := getJson(srv.URL+"/admin/once/api/job?queue=q1&type=sms",
+			&desc)
+		Ω(status).Should(Equal(http.StatusOK))
+		Ω(desc.Jid).Should(Equal(jid2))
+		Ω(desc.Status).Should(Equal(once.StatusCancelled))
+	}
+
+	{
+		res := map[string]string{}
+		status := getJson(srv.URL+"/admin/once/api/job?queue=q1&type=push",
+			&res)
+		Ω(status).Should(Equal(http.StatusNotFound))
+		Ω(res["error"]).Should(Equal(once.NoMatchingJobsErr.Error()))
+	}
+}
+
+func TestHandler_UniqueKey(t *testing.T) {
+	RegisterTestingT(t)
+
+	srv, client := newTestServer()
+	defer srv.Close()
+
+	opts := &once.Options{UniqueByArgs: true}
+	jid, err := client.Enqueue("q1", "email", []int{1}, opts)
+	Ω(err).Should(BeNil())
+	_, err = client.Enqueue("q1", "email", []int{2}, opts)
+	Ω(err).Should(BeNil())
+
+	desc, err := client.GetDesc("q1", "email", []int{1})
+	Ω(err).Should(BeNil())
+
+	{
+		// By the args
+		res := once.JobDesc{}
+		status := getJson(srv.URL+"/admin/once/api/job?"+url.Values{
+			"queue": {"q1"},
+			"type":  {"email"},
+			"args":  {"[1]"},
+		}.Encode(), &res)
+		Ω(status).Should(Equal(http.StatusOK))
+		Ω(res.Jid).Should(Equal(jid))
+	}
+
+	{
+		// By the listed unique key
+		res := once.JobDesc{}
+		status := getJson(srv.URL+"/admin/once/api/job?"+url.Values{
+			"queue":      {"q1"},
+			"type":       {"email"},
+			"unique_key": {desc.UniqueKey},
+		}.Encode(), &res)
+		Ω(status).Should(Equal(http.StatusOK))
+		Ω(res.Jid).Should(Equal(jid))
+	}
+
+	{
+		resp, err := http.PostForm(srv.URL+"/admin/once/api/force",
+			url.Values{"queue": {"q1"}, "type": {"email"}, "args": {"[1]"}})
+		Ω(err).Should(BeNil())
+		resp.Body.Close()
+		Ω(resp.StatusCode).Should(Equal(http.StatusOK))
+
+		// The job with the same args is replaced
+		res, err := client.GetDesc("q1", "email", []int{1})
+		Ω(err).Should(BeNil())
+		Ω(res.Jid).ShouldNot(Equal(jid))
+		Ω(res.UniqueKey).Should(Equal(desc.UniqueKey))
+	}
+
+	{
+		// Forcing requires the args
+		resp, err := http.PostForm(srv.URL+"/admin/once/api/force",
+			url.Values{
+				"queue":   {"q1"},
+				"type":    {"email"},
+				"options": {`{"unique_by_args":true}`},
+			})
+		Ω(err).Should(BeNil())
+		resp.Body.Close()
+		Ω(resp.StatusCode).Should(Equal(http.StatusBadRequest))
+	}
+}
+
+func TestHandler_Actions(t *testing.T) {
+	RegisterTestingT(t)
+
+	srv, client := newTestServer()
+	defer srv.Close()
+
+	jid, err := client.Enqueue("q1", "email", nil, &once.Options{
+		SuccessRetention: 77,
+	})
+	Ω(err).Should(BeNil())
+
+	{
+		resp, err := http.Get(srv.URL + "/admin/once/api/cancel")
+		Ω(err).Should(BeNil())
+		resp.Body.Close()
+		Ω(resp.StatusCode).Should(Equal(http.StatusMethodNotAllowed))
+	}
+
+	{
+		resp, err := http.PostForm(srv.URL+"/admin/once/api/cancel",
+			url.Values{"queue": {"q1"}, "type": {"email"}, "jid": {"other"}})
+		Ω(err).Should(BeNil())
+		resp.Body.Close()
+		Ω(resp.StatusCode).Should(Equal(http.StatusConflict))
+	}
+
+	{
+		resp, err := http.PostForm(srv.URL+"/admin/once/api/cancel",
+			url.Values{"queue": {"q1"}, "type": {"email"}, "jid": {jid}})
+		Ω(err).Should(BeNil())
+		resp.Body.Close()
+		Ω(resp.StatusCode).Should(Equal(http.StatusNoContent))
+
+		desc, err := client.GetDesc("q1", "email")
+		Ω(err).Should(BeNil())
+		Ω(desc.Status).Should(Equal(once.StatusCancelled))
+	}
+
+	{
+		resp, err := http.PostForm(srv.URL+"/admin/once/api/force",
+			url.Values{"queue": {"q1"}, "type": {"email"}})
+		Ω(err).Should(BeNil())
+		defer resp.Body.Close()
+		Ω(resp.StatusCode).Should(Equal(http.StatusOK))
+
+		res := map[string]string{}
+		Ω(json.NewDecoder(resp.Body).Decode(&res)).Should(BeNil())
+		Ω(res["jid"]).ShouldNot(Equal(jid))
+
+		// The options of the replaced job are kept
+		desc, err := client.GetDesc("q1", "email")
+		Ω(err).Should(BeNil())
+		Ω(desc.Jid).Should(Equal(res["jid"]))
+		Ω(desc.Options.SuccessRetention).Should(Equal(77))
+	}
+
+	{
+		// The dashboard forms redirect back
+		noRedirect := &http.Client{
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+		resp, err := noRedirect.PostForm(srv.URL+"/admin/once/api/force",
+			url.Values{
+				"queue":    {"q1"},
+				"type":     {"email"},
+				"redirect": {"../job?queue=q1&type=email"},
+			})
+		Ω(err).Should(BeNil())
+		resp.Body.Close()
+		Ω(resp.StatusCode).Should(Equal(http.StatusSeeOther))
+		Ω(resp.Header.Get("Location")).Should(
+			Equal("../job?queue=q1&type=email"))
+
+		loc, err := resp.Request.URL.Parse(resp.Header.Get("Location"))
+		Ω(err).Should(BeNil())
+		Ω(loc.Path).Should(Equal("/admin/once/job"))
+
+		desc, err := client.GetDesc("q1", "email")
+		Ω(err).Should(BeNil())
+
+		// But only to the dashboard pages
+		for _, location := range []string{
+			"https://example.com/",
+			"//example.com/",
+			`\\example.com/`,
+			`\/example.com/`,
+			`..\job`,
+			"/admin/once/job",
+			"../api/jobs",
+			"../job/../../../",
+		} {
+			resp, err = noRedirect.PostForm(srv.URL+"/admin/once/api/force",
+				url.Values{
+					"queue":    {"q1"},
+					"type":     {"email"},
+					"redirect": {location},
+				})
+			Ω(err).Should(BeNil())
+			resp.Body.Close()
+			Ω(resp.StatusCode).Should(Equal(http.StatusBadRequest), location)
+		}
+
+		// Nothing is done then
+		newDesc, err := client.GetDesc("q1", "email")
+		Ω(err).Should(BeNil())
+		Ω(newDesc.Jid).Should(Equal(desc.Jid))
+	}
+}
+
+func TestHandler_CrossSite(t *testing.T) {
+	RegisterTestingT(t)
+
+	srv, client := newTestServer()
+	defer srv.Close()
+
+	jid, err := client.Enqueue("q1", "email", nil, nil)
+	Ω(err).Should(BeNil())
+
+	post := func(path string, header http.Header) int {
+		form := url.Values{"queue": {"q1"}, "type": {"email"}}
+		req, err := http.NewRequest(http.MethodPost,
+			srv.URL+"/admin/once/api/"+path,
+			strings.NewReader(form.Encode()))
+		Ω(err).Should(BeNil())
+		req.Header = header
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		resp, err := http.DefaultClient.Do(req)
+		Ω(err).Should(BeNil())
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	Ω(post("cancel", http.Header{"Sec-Fetch-Site": {"cross-site"}})).
+		Should(Equal(http.StatusForbidden))
+	Ω(post("cancel", http.Header{"Sec-Fetch-Site": {"same-site"}})).
+		Should(Equal(http.StatusForbidden))
+	Ω(post("force", http.Header{"Origin": {"https://example.com"}})).
+		Should(Equal(http.StatusForbidden))
+	Ω(post("force", http.Header{"Origin": {"null"}})).
+		Should(Equal(http.StatusForbidden))
+
+	// Nothing is done then
+	desc, err := client.GetDesc("q1", "email")
+	Ω(err).Should(BeNil())
+	Ω(desc.Jid).Should(Equal(jid))
+	Ω(desc.Status).Should(Equal(once.StatusInitWaiting))
+
+	Ω(post("force", http.Header{"Origin": {srv.URL}})).
+		Should(Equal(http.StatusOK))
+	Ω(post("cancel", http.Header{"Sec-Fetch-Site": {"same-origin"}})).
+		Should(Equal(http.StatusNoContent))
+}
+
+func TestHandler_Dashboard(t *testing.T) {
+	RegisterTestingT(t)
+
+	srv, client := newTestServer()
+	defer srv.Close()
+
+	jid, err := client.Enqueue("q1", "email", nil, nil)
+	Ω(err).Should(BeNil())
+
+	get := func(path string) (int, string) {
+		resp, err := http.Get(srv.URL + path)
+		Ω(err).Should(BeNil())
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		Ω(err).Should(BeNil())
+		return resp.StatusCode, string(body)
+	}
+
+	for _, path := range []string{"/admin/once", "/admin/once/"} {
+		status, body := get(path)
+		Ω(status).Should(Equal(http.StatusOK))
+		Ω(body).Should(ContainSubstring(jid))
+		Ω(body).Should(ContainSubstring(`href="job?queue=q1&amp;type=email"`))
+	}
+
+	{
+		status, body := get("/admin/once/job?queue=q1&type=email")
+		Ω(status).Should(Equal(http.StatusOK))
+		Ω(body).Should(ContainSubstring(jid))
+		Ω(body).Should(ContainSubstring(`action="api/cancel"`))
+		Ω(body).Should(ContainSubstring(`action="api/force"`))
+	}
+
+	{
+		status, body := get("/admin/once/job?queue=q1&type=sms")
+		Ω(status).Should(Equal(http.StatusNotFound))
+		Ω(strings.Contains(body, "no matching jobs")).Should(BeTrue())
+	}
+
+	{
+		status, _ := get("/admin/once/nope")
+		Ω(status).Should(Equal(http.StatusNotFound))
+	}
+}
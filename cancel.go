@@ -0,0 +1,206 @@
+package once
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/PlanitarInc/go-workers"
+	"github.com/gomodule/redigo/redis"
+)
+
+var AlreadyDoneErr = errors.New("job is done already")
+
+type CancelOptions struct {
+	// Jid, if set, cancels the given job only: SupersededErr is returned if
+	// another job of the same type replaced it.
+	Jid string
+	// Args, if not nil, identify the job enqueued with Options.UniqueByArgs.
+	Args interface{}
+}
+
+// Cancel cancels the job of the given type that is not done yet. The job
+// waiting to start is removed from the go-workers schedule (or the retry
+// set); otherwise Middleware skips it once it is picked up. A running job is
+// not interrupted, it should poll IsCancelled instead.
+//
+// Returns NoMatchingJobsErr if there is no such job, and AlreadyDoneErr if
+// the job is done already.
+func Cancel(queue, jobType string, options ...CancelOptions) error {
+	return defaultClient().Cancel(queue, jobType, options...)
+}
+
+// CancelContext is like Cancel but uses the given context for obtaining
+// a Redis connection.
+func CancelContext(
+	ctx context.Context,
+	queue, jobType string,
+	options ...CancelOptions,
+) error {
+	return defaultClient().CancelContext(ctx, queue, jobType, options...)
+}
+
+// IsCancelled tells the job handler whether the job being processed was
+// cancelled.
+func IsCancelled(message *workers.Msg) (bool, error) {
+	return defaultClient().IsCancelled(context.Background(), message)
+}
+
+// Cancel is the client's counterpart of the package-level Cancel.
+func (c *Client) Cancel(
+	queue, jobType string,
+	options ...CancelOptions,
+) error {
+	return c.CancelContext(context.Background(), queue, jobType, options...)
+}
+
+// CancelContext is the client's counterpart of the package-level
+// CancelContext.
+func (c *Client) CancelContext(
+	ctx context.Context,
+	queue, jobType string,
+	options ...CancelOptions,
+) error {
+	opts := CancelOptions{}
+	if len(options) > 0 {
+		opts = options[0]
+	}
+
+	uniqueKey := ""
+	if opts.Args != nil {
+		var err error
+		if uniqueKey, err = c.uniqueKey(jobType, opts.Args); err != nil {
+			return err
+		}
+	}
+
+	key := c.key(queue, jobType, uniqueKey)
+	store := c.store()
+
+	desc, err := store.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	if opts.Jid != "" && desc.Jid != opts.Jid {
+		return SupersededErr
+	}
+
+	retention := optionsMergeDefaults(nil).FailureRetention
+	if desc.Options != nil && desc.Options.FailureRetention > 0 {
+		retention = desc.Options.FailureRetention
+	}
+	if desc.Status == StatusExecuting {
+		// Keep the descriptor until the heartbeat of the running job
+		// notices the cancellation; Middleware applies the retention once
+		// the job ends
+		lease := optionsMergeDefaults(nil).ExecWaitTime
+		if desc.Options != nil && desc.Options.ExecWaitTime > 0 {
+			lease = desc.Options.ExecWaitTime
+		}
+		if lease > retention {
+			retention = lease
+		}
+	}
+
+	n, err := store.Cancel(ctx, key, desc.Jid, retention, time.Now())
+	if err != nil {
+		return err
+	}
+
+	switch n {
+	case -1:
+		return NoMatchingJobsErr
+	case -2:
+		return SupersededErr
+	case -3:
+		return AlreadyDoneErr
+	}
+
+	if desc.Status == StatusInitWaiting || desc.Status == StatusRetryWaiting {
+		return c.unschedule(ctx, desc.Jid)
+	}
+
+	return nil
+}
+
+// IsCancelled is the client's counterpart of the package-level IsCancelled.
+func (c *Client) IsCancelled(
+	ctx context.Context,
+	message *workers.Msg,
+) (bool, error) {
+	key, ok := c.msgKey(message)
+	if !ok {
+		return false, NotOnceJobErr
+	}
+
+	desc, err := c.store().Get(ctx, key)
+	if err != nil {
+		return false, err
+	}
+
+	return desc.Jid == message.Jid() && desc.IsCancelled(), nil
+}
+
+// unschedule removes the message of the job from the go-workers schedule
+// and retry sets.
+func (c *Client) unschedule(ctx context.Context, jid string) error {
+	// The messages are handed over elsewhere
+	if c.EnqueueMsg != nil {
+		return nil
+	}
+
+	conn, err := c.Pool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	pattern := `*"jid":"` + escapeGlob(jid) + `"*`
+	sets := []string{
+		c.Namespace + workers.SCHEDULED_JOBS_KEY,
+		c.Namespace + workers.RETRY_KEY,
+	}
+
+	for _, set := range sets {
+		cursor := 0
+		for {
+			res, err := redis.Values(conn.Do("ZSCAN", set, cursor,
+				"MATCH", pattern))
+			if err != nil {
+				return err
+			}
+
+			var members []string
+			if _, err := redis.Scan(res, &cursor, &members); err != nil {
+				return err
+			}
+
+			// The members are followed by their scores
+			for i := 0; i < len(members); i += 2 {
+				// MATCH is only a hint, the JID might be found elsewhere
+				msg, err := workers.NewMsg(members[i])
+				if err != nil || msg.Jid() != jid {
+					continue
+				}
+
+				if _, err := conn.Do("ZREM", set, members[i]); err != nil {
+					return err
+				}
+			}
+
+			if cursor == 0 {
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+var globEscaper = strings.NewReplacer(
+	`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
+
+func escapeGlob(s string) string {
+	return globEscaper.Replace(s)
+}
@@ -0,0 +1,38 @@
+-- KEYS:
+--  [1] key of the job descriptor
+-- ARGUMENTS:
+--  [1] Expected JID
+--  [2] New expiration time for the job descriptor
+--  [3] New last update timestamp (in ms) for the job descriptor
+--
+--  Return values:
+--    0  in case of success
+--   -1  if the key does not exist
+--   -2  if the JID is wrong
+--   -3  if the job is done already
+
+local val = redis.call("GET", KEYS[1])
+
+if val == false then
+  return -1
+end
+
+val = cjson.decode(val)
+if val["jid"] ~= ARGV[1] then
+  return -2
+end
+
+local status = val["status"]
+if status == "ok" or status == "failed" or status == "timed-out" or
+    status == "cancelled" then
+  return -3
+end
+
+val["status"] = "cancelled"
+val["updated_ms"] = tonumber(ARGV[3])
+
+redis.call("SET", KEYS[1], cjson.encode(val), "EX", ARGV[2])
+-- Notify the waiters and the watchers
+val["event"] = "status"
+redis.call("PUBLISH", KEYS[1], cjson.encode(val))
+return 0
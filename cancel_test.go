@@ -0,0 +1,260 @@
+package once
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/PlanitarInc/go-workers"
+	"github.com/gomodule/redigo/redis"
+	. "github.com/onsi/gomega"
+)
+
+func TestCancel_Scheduled(t *testing.T) {
+	RegisterTestingT(t)
+
+	setupRedis()
+	defer cleanRedis()
+
+	conn := workers.Config.Pool.Get()
+	defer conn.Close()
+
+	queue := "cancel-1"
+	jobType := "email"
+	schedule := workers.Config.Namespace + workers.SCHEDULED_JOBS_KEY
+
+	other, err := EnqueueIn(queue, "other", time.Minute, nil, nil)
+	Ω(err).Should(BeNil())
+	jid, err := EnqueueIn(queue, jobType, time.Minute, nil, nil)
+	Ω(err).Should(BeNil())
+
+	{
+		err := Cancel(queue, jobType, CancelOptions{Jid: jid})
+		Ω(err).Should(BeNil())
+	}
+
+	{
+		desc, err := GetDesc(queue, jobType)
+		Ω(err).Should(BeNil())
+		Ω(desc.Jid).Should(Equal(jid))
+		Ω(desc.Status).Should(Equal(StatusCancelled))
+	}
+
+	{
+		// The message is gone from the schedule
+		res, err := redis.Strings(conn.Do("ZRANGE", schedule, 0, -1))
+		Ω(err).Should(BeNil())
+		Ω(res).Should(HaveLen(1))
+
+		msg, err := workers.NewMsg(res[0])
+		Ω(err).Should(BeNil())
+		Ω(msg.Jid()).Should(Equal(other))
+	}
+
+	{
+		// The waiters are released
+		desc, err := WaitForJobType(queue, jobType, WaitOptions{
+			Timeout: time.Second,
+		})
+		Ω(err).Should(BeNil())
+		Ω(desc.Status).Should(Equal(StatusCancelled))
+	}
+
+	{
+		err := Cancel(queue, jobType)
+		Ω(err).Should(Equal(AlreadyDoneErr))
+	}
+
+	{
+		// A new job replaces the cancelled one
+		newJid, err := Enqueue(queue, jobType, nil, nil)
+		Ω(err).Should(BeNil())
+		Ω(newJid).ShouldNot(Equal(jid))
+
+		err = Cancel(queue, jobType, CancelOptions{Jid: jid})
+		Ω(err).Should(Equal(SupersededErr))
+	}
+}
+
+func TestCancel_Queued(t *testing.T) {
+	RegisterTestingT(t)
+
+	setupRedis()
+	defer cleanRedis()
+
+	queue := "cancel-2"
+	jobType := "email"
+
+	{
+		err := Cancel(queue, jobType)
+		Ω(err).Should(Equal(NoMatchingJobsErr))
+	}
+
+	jid, err := Enqueue(queue, jobType, nil, nil)
+	Ω(err).Should(BeNil())
+
+	Ω(Cancel(queue, jobType)).Should(BeNil())
+
+	msg, _ := workers.NewMsg(`{
+		"jid": "` + jid + `",
+		"queue": "cancel-2",
+		"x-once": {"job_type": "email", "options": {"at_most_once": false}}
+	}`)
+
+	{
+		// The middleware skips the cancelled job
+		m := Middleware{}
+		counter, noopNext := getCountableCb()
+		ack := m.Call(queue, msg, noopNext)
+		Ω(ack).Should(BeTrue())
+		Ω(*counter).Should(Equal(0))
+	}
+
+	{
+		desc, err := GetDesc(queue, jobType)
+		Ω(err).Should(BeNil())
+		Ω(desc.Status).Should(Equal(StatusCancelled))
+	}
+}
+
+func TestCancel_Running(t *testing.T) {
+	RegisterTestingT(t)
+
+	setupRedis()
+	defer cleanRedis()
+
+	queue := "cancel-3"
+	jobType := "email"
+
+	jid, err := Enqueue(queue, jobType, nil, nil)
+	Ω(err).Should(BeNil())
+
+	msg, _ := workers.NewMsg(`{
+		"jid": "` + jid + `",
+		"queue": "cancel-3",
+		"x-once": {"job_type": "email"}
+	}`)
+
+	m := Middleware{}
+	ack := m.Call(queue, msg, func() bool {
+		cancelled, err := IsCancelled(msg)
+		Ω(err).Should(BeNil())
+		Ω(cancelled).Should(BeFalse())
+
+		Ω(Cancel(queue, jobType)).Should(BeNil())
+
+		// The handler polls the flag
+		cancelled, err = IsCancelled(msg)
+		Ω(err).Should(BeNil())
+		Ω(cancelled).Should(BeTrue())
+
+		return true
+	})
+	Ω(ack).Should(BeTrue())
+
+	{
+		// The job stays cancelled
+		desc, err := WaitForJid(queue, jobType, jid, WaitOptions{
+			Timeout: time.Second,
+		})
+		Ω(err).Should(BeNil())
+		Ω(desc.Status).Should(Equal(StatusCancelled))
+	}
+}
+
+func TestCancel_RunningContext(t *testing.T) {
+	RegisterTestingT(t)
+
+	setupRedis()
+	defer cleanRedis()
+
+	queue := "cancel-ctx"
+	jobType := "email"
+
+	jid, err := Enqueue(queue, jobType, nil, nil)
+	Ω(err).Should(BeNil())
+
+	msg, _ := workers.NewMsg(`{
+		"jid": "` + jid + `",
+		"queue": "cancel-ctx",
+		"x-once": {"job_type": "email"}
+	}`)
+
+	m := Middleware{HeartbeatInterval: 50 * time.Millisecond}
+	ack := m.Call(queue, msg, func() bool {
+		Ω(Cancel(queue, jobType)).Should(BeNil())
+
+		// The heartbeat notices the job was cancelled
+		Eventually(JobContext(msg).Done()).Should(BeClosed())
+		Ω(JobContext(msg).Err()).Should(Equal(context.Canceled))
+
+		return true
+	})
+	Ω(ack).Should(BeTrue())
+}
+
+func TestCancel_RunningDefaultHeartbeat(t *testing.T) {
+	RegisterTestingT(t)
+
+	setupRedis()
+	defer cleanRedis()
+
+	conn := workers.Config.Pool.Get()
+	defer conn.Close()
+
+	queue := "cancel-hb"
+	jobType := "email"
+	key := workers.Config.Namespace + "once:q:cancel-hb:email"
+
+	// The heartbeat runs every 3 seconds, after the failure retention
+	opts := &Options{ExecWaitTime: 9, FailureRetention: 1}
+	jid, err := Enqueue(queue, jobType, nil, opts)
+	Ω(err).Should(BeNil())
+
+	msg, _ := workers.NewMsg(`{
+		"jid": "` + jid + `",
+		"queue": "cancel-hb",
+		"x-once": {
+			"job_type": "email",
+			"options": {"exec_wait": 9, "failure_retention": 1}
+		}
+	}`)
+
+	lost := false
+	m := Middleware{
+		OnLeaseLost: func(desc *JobDesc, message *workers.Msg) {
+			lost = true
+		},
+	}
+	ack := m.Call(queue, msg, func() bool {
+		Ω(Cancel(queue, jobType)).Should(BeNil())
+
+		// The descriptor outlives the failure retention
+		ttl, err := redis.Int(conn.Do("TTL", key))
+		Ω(err).Should(BeNil())
+		Ω(ttl).Should(BeNumerically("~", 9, 1))
+
+		Eventually(JobContext(msg).Done(), 5*time.Second).
+			Should(BeClosed())
+		Ω(JobContext(msg).Err()).Should(Equal(context.Canceled))
+		Ω(IsCancelled(msg)).Should(BeTrue())
+
+		return true
+	})
+	Ω(ack).Should(BeTrue())
+	Ω(lost).Should(BeFalse())
+
+	{
+		desc, err := GetDesc(queue, jobType)
+		Ω(err).Should(BeNil())
+		Ω(desc.Status).Should(Equal(StatusCancelled))
+		Ω(desc.Attempts).Should(HaveLen(1))
+		Ω(desc.Attempts[0].Outcome).Should(Equal(StatusCancelled))
+		Ω(desc.Attempts[0].EndMs).ShouldNot(BeZero())
+
+		// The retention is applied once the job ends
+		ttl, err := redis.Int(conn.Do("TTL", key))
+		Ω(err).Should(BeNil())
+		Ω(ttl).Should(BeNumerically("~", 1, 1))
+	}
+}
@@ -0,0 +1,209 @@
+package once
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/PlanitarInc/go-workers"
+	"github.com/gomodule/redigo/redis"
+)
+
+const DefaultKeyPrefix = "once:q:"
+
+// Client enqueues and tracks once-jobs using its own Redis pool and
+// namespace, independently of the global workers.Config.
+//
+// The package-level functions use a default client built from
+// workers.Config, rebuilt whenever the pool or the namespace of the latter
+// changes. The clients share nothing, so it's best to keep using the same
+// client, e.g. for its store to share a pub/sub connection among all the
+// waiters.
+type Client struct {
+	Pool *redis.Pool
+	// Namespace is prepended to all the keys, including the go-workers
+	// queues. Similarly to workers.Config.Namespace, it should include the
+	// separator, e.g. "myns:".
+	Namespace string
+	// KeyPrefix is prepended to the job descriptor keys after the
+	// namespace. DefaultKeyPrefix is used if empty. The keys of the other
+	// data, e.g. the semaphores of Options.MaxConcurrency, are derived from
+	// it, so it should end with "q:".
+	KeyPrefix string
+	// Options are used when nil options are passed to Enqueue*.
+	Options *Options
+	// Store keeps the job descriptors. A RedisStore on top of Pool is used
+	// if nil.
+	Store Store
+	// EnqueueMsg, if set, hands the job messages over instead of pushing
+	// them to the go-workers queues through Pool. Together with a
+	// MemoryStore it allows running without Redis.
+	EnqueueMsg func(ctx context.Context, msg *workers.Msg) error
+	// UniqueKeyFunc derives the deduplication keys of the jobs enqueued
+	// with Options.UniqueByArgs, and of the args passed to GetDesc and
+	// WaitForJobType. HashArgs is used if nil.
+	UniqueKeyFunc UniqueKeyFunc
+	// Metrics, if set, receives the measurements of the client and its
+	// middleware.
+	Metrics Metrics
+
+	// redisStore is used if Store is nil, created once so all the waiters
+	// share its pub/sub connection.
+	redisStoreOnce sync.Once
+	redisStore     *RedisStore
+}
+
+func NewClient(pool *redis.Pool, namespace string) *Client {
+	return &Client{
+		Pool:      pool,
+		Namespace: namespace,
+		KeyPrefix: DefaultKeyPrefix,
+		Store:     NewRedisStore(pool),
+	}
+}
+
+var defaultClientCache struct {
+	sync.Mutex
+	client  *Client
+	metrics Metrics
+}
+
+func defaultClient() *Client {
+	defaultClientCache.Lock()
+	defer defaultClientCache.Unlock()
+
+	c := defaultClientCache.client
+	if c == nil || c.Pool != workers.Config.Pool ||
+		c.Namespace != workers.Config.Namespace {
+		c = NewClient(workers.Config.Pool, workers.Config.Namespace)
+		c.Metrics = defaultClientCache.metrics
+		defaultClientCache.client = c
+	}
+
+	return c
+}
+
+// Middleware returns a go-workers middleware tracking the once-jobs
+// enqueued by the client.
+func (c *Client) Middleware() *Middleware {
+	return &Middleware{client: c}
+}
+
+func (c *Client) key(queue, jobType, uniqueKey string) string {
+	key := c.keyPrefix() + queue + ":" + jobType
+	if uniqueKey != "" {
+		key += ":" + uniqueKey
+	}
+
+	return key
+}
+
+// keyPrefix returns the common prefix of the job descriptor keys.
+func (c *Client) keyPrefix() string {
+	prefix := c.KeyPrefix
+	if prefix == "" {
+		prefix = DefaultKeyPrefix
+	}
+
+	return c.Namespace + prefix
+}
+
+// semaphoreKey returns the key of the semaphore limiting the concurrency
+// of the jobs of the given type. The semaphores are kept next to the
+// descriptors: the trailing "q:" of the key prefix is replaced by "sem:",
+// e.g. "once:sem:".
+func (c *Client) semaphoreKey(queue, jobType string) string {
+	return strings.TrimSuffix(c.keyPrefix(), "q:") + "sem:" + queue + ":" +
+		jobType
+}
+
+// msgKey returns the key of the descriptor of the once-job carried by the
+// message, false if the message is not a once-job.
+func (c *Client) msgKey(message *workers.Msg) (string, bool) {
+	queue, _ := message.Get("queue").String()
+	return c.queueMsgKey(queue, message)
+}
+
+// queueMsgKey is like msgKey but takes the (possibly namespaced) queue the
+// message was fetched from, as passed to the middlewares.
+func (c *Client) queueMsgKey(
+	queue string,
+	message *workers.Msg,
+) (string, bool) {
+	jobDesc, ok := message.CheckGet("x-once")
+	if !ok {
+		return "", false
+	}
+
+	jobType, _ := jobDesc.Get("job_type").String()
+	uniqueKey, _ := jobDesc.Get("unique_key").String()
+
+	return c.key(strings.TrimPrefix(queue, c.Namespace), jobType,
+		uniqueKey), true
+}
+
+func (c *Client) uniqueKey(jobType string, args interface{}) (string, error) {
+	if c.UniqueKeyFunc != nil {
+		return c.UniqueKeyFunc(jobType, args)
+	}
+
+	return HashArgs(jobType, args)
+}
+
+func (c *Client) store() Store {
+	if c.Store == nil {
+		c.redisStoreOnce.Do(func() {
+			c.redisStore = NewRedisStore(c.Pool)
+		})
+		return c.redisStore
+	}
+
+	return c.Store
+}
+
+func (c *Client) options(opts *Options) *Options {
+	if opts == nil && c.Options != nil {
+		tmp := *c.Options
+		opts = &tmp
+	}
+
+	return opts
+}
+
+// enqueueMsg pushes the message to its queue or to the schedule, the same
+// way workers.EnqueueMsg does but within the client's namespace.
+func (c *Client) enqueueMsg(ctx context.Context, msg *workers.Msg) error {
+	now := workers.NowToSecondsWithNanoPrecision()
+
+	msg.Set("enqueued_at", now)
+	bytes, _ := msg.Encode()
+	queue, _ := msg.Get("queue").String()
+
+	if c.EnqueueMsg != nil {
+		// Hand over the message the way the workers see it
+		decoded, err := workers.NewMsg(string(bytes))
+		if err != nil {
+			return err
+		}
+		return c.EnqueueMsg(ctx, decoded)
+	}
+
+	conn, err := c.Pool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if at, _ := msg.Get("at").Float64(); now < at {
+		_, err := conn.Do("zadd",
+			c.Namespace+workers.SCHEDULED_JOBS_KEY, at, bytes)
+		return err
+	}
+
+	if _, err := conn.Do("sadd", c.Namespace+"queues", queue); err != nil {
+		return err
+	}
+
+	_, err = conn.Do("rpush", c.Namespace+"queue:"+queue, bytes)
+	return err
+}
@@ -0,0 +1,201 @@
+package once
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/PlanitarInc/go-workers"
+	"github.com/gomodule/redigo/redis"
+	. "github.com/onsi/gomega"
+)
+
+func TestClientKey(t *testing.T) {
+	RegisterTestingT(t)
+
+	{
+		c := NewClient(nil, "ns:")
+		Ω(c.key("q", "t", "")).Should(Equal("ns:once:q:q:t"))
+		Ω(c.key("q", "t", "u")).Should(Equal("ns:once:q:q:t:u"))
+	}
+
+	{
+		c := &Client{Namespace: "ns:"}
+		Ω(c.key("q", "t", "")).Should(Equal("ns:once:q:q:t"))
+	}
+
+	{
+		c := &Client{KeyPrefix: "o:"}
+		Ω(c.key("q", "t", "")).Should(Equal("o:q:t"))
+	}
+
+	{
+		c := NewClient(nil, "ns:")
+		Ω(c.semaphoreKey("q", "t")).Should(Equal("ns:once:sem:q:t"))
+	}
+
+	{
+		c := &Client{Namespace: "ns:", KeyPrefix: "app:q:"}
+		Ω(c.semaphoreKey("q", "t")).Should(Equal("ns:app:sem:q:t"))
+	}
+}
+
+func TestClientStore(t *testing.T) {
+	RegisterTestingT(t)
+
+	{
+		// The default store is shared by all the calls
+		c := &Client{}
+		store := c.store()
+		Ω(store).Should(BeAssignableToTypeOf(&RedisStore{}))
+		Ω(c.store()).Should(BeIdenticalTo(store))
+	}
+
+	{
+		store := NewMemoryStore()
+		c := &Client{Store: store}
+		Ω(c.store()).Should(BeIdenticalTo(store))
+	}
+}
+
+func TestClientEnqueue(t *testing.T) {
+	RegisterTestingT(t)
+
+	setupRedis()
+	defer cleanRedis()
+
+	conn := workers.Config.Pool.Get()
+	defer conn.Close()
+
+	c := newTestClient("clientns:")
+	defer c.Pool.Close()
+
+	jid, err := c.Enqueue("client-q", "typo", []int{1, 2}, nil)
+	Ω(err).Should(BeNil())
+	Ω(jid).ShouldNot(BeEmpty())
+
+	{
+		desc, err := c.GetDesc("client-q", "typo")
+		Ω(err).Should(BeNil())
+		Ω(desc.Jid).Should(Equal(jid))
+		Ω(desc.Status).Should(Equal(StatusInitWaiting))
+	}
+
+	{
+		// The default client does not see the job
+		desc, err := GetDesc("client-q", "typo")
+		Ω(err).Should(Equal(NoMatchingJobsErr))
+		Ω(desc).Should(BeNil())
+	}
+
+	{
+		n, err := redis.Int(conn.Do("llen", "clientns:queue:client-q"))
+		Ω(err).Should(BeNil())
+		Ω(n).Should(Equal(1))
+	}
+
+	{
+		isMember, err := redis.Bool(conn.Do("sismember", "clientns:queues", "client-q"))
+		Ω(err).Should(BeNil())
+		Ω(isMember).Should(BeTrue())
+	}
+
+	{
+		bs, err := redis.String(conn.Do("lpop", "clientns:queue:client-q"))
+		Ω(err).Should(BeNil())
+
+		msg, err := workers.NewMsg(bs)
+		Ω(err).Should(BeNil())
+		Ω(msg.Jid()).Should(Equal(jid))
+
+		m := c.Middleware()
+		counter, noopNext := getCountableCb()
+		ack := m.Call("clientns:client-q", msg, noopNext)
+		Ω(ack).Should(BeTrue())
+		Ω(*counter).Should(Equal(1))
+	}
+
+	{
+		desc, err := c.WaitForJobType("client-q", "typo")
+		Ω(err).Should(BeNil())
+		Ω(desc.Jid).Should(Equal(jid))
+		Ω(desc.Status).Should(Equal(StatusOK))
+	}
+}
+
+func TestClientEnqueueIn(t *testing.T) {
+	RegisterTestingT(t)
+
+	setupRedis()
+	defer cleanRedis()
+
+	conn := workers.Config.Pool.Get()
+	defer conn.Close()
+
+	c := newTestClient("clientns:")
+	defer c.Pool.Close()
+
+	jid, err := c.EnqueueIn("client-q", "typo", time.Minute, nil,
+		&Options{InitWaitTime: 120})
+	Ω(err).Should(BeNil())
+	Ω(jid).ShouldNot(BeEmpty())
+
+	{
+		n, err := redis.Int(conn.Do("zcard", "clientns:schedule"))
+		Ω(err).Should(BeNil())
+		Ω(n).Should(Equal(1))
+	}
+
+	{
+		res, err := redis.Int(conn.Do("TTL", "clientns:once:q:client-q:typo"))
+		Ω(err).Should(BeNil())
+		Ω(res).Should(Equal(120))
+	}
+}
+
+func TestClient_MemoryStore(t *testing.T) {
+	RegisterTestingT(t)
+
+	msgs := make(chan *workers.Msg, 1)
+	c := &Client{
+		Namespace: "memns:",
+		Store:     NewMemoryStore(),
+		EnqueueMsg: func(ctx context.Context, msg *workers.Msg) error {
+			msgs <- msg
+			return nil
+		},
+	}
+
+	jid, err := c.Enqueue("mem-q", "typo", nil, nil)
+	Ω(err).Should(BeNil())
+
+	{
+		other, err := c.Enqueue("mem-q", "typo", nil, nil)
+		Ω(err).Should(BeNil())
+		Ω(other).Should(Equal(jid))
+	}
+
+	var msg *workers.Msg
+	Ω(msgs).Should(Receive(&msg))
+	Ω(msgs).ShouldNot(Receive())
+	Ω(msg.Jid()).Should(Equal(jid))
+
+	doneC := make(chan struct{})
+	go func() {
+		defer close(doneC)
+
+		desc, err := c.WaitForJobType("mem-q", "typo")
+		Ω(err).Should(BeNil())
+		Ω(desc.Jid).Should(Equal(jid))
+		Ω(desc.Status).Should(Equal(StatusOK))
+	}()
+
+	{
+		counter, noopNext := getCountableCb()
+		ack := c.Middleware().Call("memns:mem-q", msg, noopNext)
+		Ω(ack).Should(BeTrue())
+		Ω(*counter).Should(Equal(1))
+	}
+
+	Eventually(doneC).Should(BeClosed())
+}
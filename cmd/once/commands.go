@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	once "github.com/PlanitarInc/go-workers-once"
+)
+
+// jobFlags are the flags and args identifying a job type.
+type jobFlags struct {
+	*flag.FlagSet
+	args string
+
+	queue, jobType string
+}
+
+func newJobFlags(name string) *jobFlags {
+	f := &jobFlags{FlagSet: flag.NewFlagSet(name, flag.ContinueOnError)}
+	f.StringVar(&f.args, "args", "",
+		"JSON args of the job enqueued with unique_by_args")
+	return f
+}
+
+func (f *jobFlags) Parse(args []string) error {
+	if err := f.FlagSet.Parse(args); err != nil {
+		return err
+	}
+	if f.NArg() != 2 {
+		return errors.New("expected <queue> <job-type>")
+	}
+	f.queue, f.jobType = f.Arg(0), f.Arg(1)
+	return nil
+}
+
+// jobArgs returns the decoded args, or nil if not given.
+func (f *jobFlags) jobArgs() (interface{}, error) {
+	if f.args == "" {
+		return nil, nil
+	}
+
+	var args interface{}
+	if err := json.Unmarshal([]byte(f.args), &args); err != nil {
+		return nil, fmt.Errorf("invalid -args: %v", err)
+	}
+	return args, nil
+}
+
+// argsList returns the decoded args as the optional args of GetDesc.
+func (f *jobFlags) argsList() ([]interface{}, error) {
+	args, err := f.jobArgs()
+	if err != nil || args == nil {
+		return nil, err
+	}
+	return []interface{}{args}, nil
+}
+
+// filterFlags are the flags of a ListFilter.
+type filterFlags struct {
+	*flag.FlagSet
+	filter   once.ListFilter
+	statuses string
+}
+
+func newFilterFlags(name string) *filterFlags {
+	f := &filterFlags{FlagSet: flag.NewFlagSet(name, flag.ContinueOnError)}
+	f.StringVar(&f.filter.Queue, "queue", "", "queue of the jobs")
+	f.StringVar(&f.filter.JobTypePrefix, "type", "",
+		"prefix of the job types")
+	f.StringVar(&f.statuses, "status", "",
+		"comma-separated statuses of the jobs")
+	f.DurationVar(&f.filter.MinAge, "min-age", 0,
+		"minimum time since the last update")
+	f.DurationVar(&f.filter.MaxAge, "max-age", 0,
+		"maximum time since the last update")
+	f.IntVar(&f.filter.Limit, "page", 100, "jobs scanned at a time")
+	return f
+}
+
+func (f *filterFlags) Parse(args []string) error {
+	if err := f.FlagSet.Parse(args); err != nil {
+		return err
+	}
+	if f.NArg() != 0 {
+		return errors.New("unexpected arguments")
+	}
+	if f.statuses != "" {
+		f.filter.Statuses = strings.Split(f.statuses, ",")
+	}
+	return nil
+}
+
+// listAll calls fn with every page of the jobs matching the filter.
+func listAll(
+	ctx context.Context,
+	filter once.ListFilter,
+	fn func(descs []*once.JobDesc) error,
+) error {
+	for {
+		page, err := once.List(ctx, filter)
+		if err != nil {
+			return err
+		}
+		if len(page.Jobs) > 0 {
+			if err := fn(page.Jobs); err != nil {
+				return err
+			}
+		}
+		if page.Cursor == "" {
+			return nil
+		}
+		filter.Cursor = page.Cursor
+	}
+}
+
+func runList(ctx context.Context, out *output, args []string) error {
+	f := newFilterFlags("list")
+	if err := f.Parse(args); err != nil {
+		return err
+	}
+
+	descs := []*once.JobDesc{}
+	err := listAll(ctx, f.filter, func(page []*once.JobDesc) error {
+		descs = append(descs, page...)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return out.Descs(descs)
+}
+
+func runGet(ctx context.Context, out *output, args []string) error {
+	f := newJobFlags("get")
+	if err := f.Parse(args); err != nil {
+		return err
+	}
+	jobArgs, err := f.argsList()
+	if err != nil {
+		return err
+	}
+
+	desc, err := once.GetDescContext(ctx, f.queue, f.jobType, jobArgs...)
+	if err != nil {
+		return err
+	}
+
+	return out.Descs([]*once.JobDesc{desc})
+}
+
+func runWait(ctx context.Context, out *output, args []string) error {
+	f := newJobFlags("wait")
+	jid := f.String("jid", "", "JID of the awaited job")
+	timeout := f.Duration("timeout", time.Hour, "how long to wait")
+	if err := f.Parse(args); err != nil {
+		return err
+	}
+	jobArgs, err := f.jobArgs()
+	if err != nil {
+		return err
+	}
+
+	opts := once.WaitOptions{Timeout: *timeout, Args: jobArgs}
+	var desc *once.JobDesc
+	if *jid != "" {
+		desc, err = once.WaitForJidContext(ctx, f.queue, f.jobType, *jid, opts)
+	} else {
+		desc, err = once.WaitForJobTypeContext(ctx, f.queue, f.jobType, opts)
+	}
+	if err != nil {
+		return err
+	}
+
+	return out.Descs([]*once.JobDesc{desc})
+}
+
+func runEnqueue(ctx context.Context, out *output, args []string) error {
+	return enqueue(ctx, out, "enqueue", args, false)
+}
+
+func runForce(ctx context.Context, out *output, args []string) error {
+	return enqueue(ctx, out, "force", args, true)
+}
+
+func enqueue(
+	ctx context.Context,
+	out *output,
+	name string,
+	args []string,
+	force bool,
+) error {
+	f := newJobFlags(name)
+	optsJson := f.String("options", "", "JSON options of the job")
+	in := f.Duration("in", 0, "delay of the job")
+	if err := f.Parse(args); err != nil {
+		return err
+	}
+	jobArgs, err := f.jobArgs()
+	if err != nil {
+		return err
+	}
+
+	var opts *once.Options
+	if *optsJson != "" {
+		opts = &once.Options{}
+		if err := json.Unmarshal([]byte(*optsJson), opts); err != nil {
+			return fmt.Errorf("invalid -options: %v", err)
+		}
+	}
+
+	var jid string
+	switch {
+	case force && *in > 0:
+		jid, err = once.EnqueueForceInContext(ctx, f.queue, f.jobType, *in,
+			jobArgs, opts)
+	case force:
+		jid, err = once.EnqueueForceContext(ctx, f.queue, f.jobType, jobArgs,
+			opts)
+	case *in > 0:
+		jid, err = once.EnqueueInContext(ctx, f.queue, f.jobType, *in,
+			jobArgs, opts)
+	default:
+		jid, err = once.EnqueueContext(ctx, f.queue, f.jobType, jobArgs, opts)
+	}
+	if err != nil {
+		return err
+	}
+
+	return out.Value("jid", jid)
+}
+
+func runCancel(ctx context.Context, out *output, args []string) error {
+	f := newJobFlags("cancel")
+	jid := f.String("jid", "", "JID of the job to cancel")
+	if err := f.Parse(args); err != nil {
+		return err
+	}
+	jobArgs, err := f.jobArgs()
+	if err != nil {
+		return err
+	}
+
+	return once.CancelContext(ctx, f.queue, f.jobType, once.CancelOptions{
+		Jid:  *jid,
+		Args: jobArgs,
+	})
+}
+
+func runPurge(ctx context.Context, out *output, args []string) error {
+	f := newFilterFlags("purge")
+	dryRun := f.Bool("dry-run", false, "list the jobs without removing them")
+	if err := f.Parse(args); err != nil {
+		return err
+	}
+
+	purged := []*once.JobDesc{}
+	err := listAll(ctx, f.filter, func(page []*once.JobDesc) error {
+		for _, desc := range page {
+			if !*dryRun {
+				if err := once.Delete(ctx, desc); err != nil {
+					return err
+				}
+			}
+			purged = append(purged, desc)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return out.Descs(purged)
+}
+
+func runWatch(ctx context.Context, out *output, args []string) error {
+	f := newJobFlags("watch")
+	if err := f.Parse(args); err != nil {
+		return err
+	}
+	jobArgs, err := f.argsList()
+	if err != nil {
+		return err
+	}
+
+	notifications, err := once.Watch(ctx, f.queue, f.jobType, jobArgs...)
+	if err != nil {
+		return err
+	}
+
+	for n := range notifications {
+		if err := out.Desc(n.Desc); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
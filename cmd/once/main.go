@@ -0,0 +1,122 @@
+// Command once inspects and manages the once-jobs stored in Redis.
+//
+// Usage:
+//
+//	once [flags] <command> [command flags] [args]
+//
+// The Redis connection is configured by the flags or the environment, the
+// same way as by workers.Configure:
+//
+//	-server     ONCE_SERVER     Redis address, localhost:6379 by default
+//	-database   ONCE_DATABASE   Redis database
+//	-password   ONCE_PASSWORD   Redis password
+//	-namespace  ONCE_NAMESPACE  go-workers namespace, without the separator
+//
+// The commands are:
+//
+//	list     list the jobs matching a filter
+//	get      show the job of the given type
+//	wait     wait for the job of the given type to complete
+//	enqueue  enqueue a job unless there is one of the same type
+//	force    enqueue a job replacing the one of the same type
+//	cancel   cancel the job of the given type
+//	purge    remove the descriptors of the jobs matching a filter
+//	watch    stream the changes of the job of the given type
+//
+// The output is a table, or JSON if -json is set.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/PlanitarInc/go-workers"
+)
+
+type command struct {
+	name  string
+	usage string
+	run   func(ctx context.Context, out *output, args []string) error
+}
+
+var commands = []command{
+	{"list", "[filter flags]", runList},
+	{"get", "[-args JSON] <queue> <job-type>", runGet},
+	{"wait", "[-args JSON] [-jid JID] [-timeout D] <queue> <job-type>", runWait},
+	{"enqueue", "[-args JSON] [-options JSON] [-in D] <queue> <job-type>",
+		runEnqueue},
+	{"force", "[-args JSON] [-options JSON] [-in D] <queue> <job-type>",
+		runForce},
+	{"cancel", "[-args JSON] [-jid JID] <queue> <job-type>", runCancel},
+	{"purge", "[-dry-run] [filter flags]", runPurge},
+	{"watch", "[-args JSON] <queue> <job-type>", runWatch},
+}
+
+func main() {
+	flags := flag.NewFlagSet("once", flag.ExitOnError)
+	server := flags.String("server", envOr("ONCE_SERVER", "localhost:6379"),
+		"Redis address")
+	database := flags.String("database", os.Getenv("ONCE_DATABASE"),
+		"Redis database")
+	password := flags.String("password", os.Getenv("ONCE_PASSWORD"),
+		"Redis password")
+	namespace := flags.String("namespace", os.Getenv("ONCE_NAMESPACE"),
+		"go-workers namespace")
+	asJson := flags.Bool("json", false, "print JSON")
+	flags.Usage = func() { usage(flags) }
+	flags.Parse(os.Args[1:])
+
+	if flags.NArg() == 0 {
+		usage(flags)
+		os.Exit(2)
+	}
+
+	var cmd *command
+	for i := range commands {
+		if commands[i].name == flags.Arg(0) {
+			cmd = &commands[i]
+		}
+	}
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "once: unknown command %q\n", flags.Arg(0))
+		usage(flags)
+		os.Exit(2)
+	}
+
+	workers.Configure(map[string]string{
+		"server":    *server,
+		"database":  *database,
+		"password":  *password,
+		"namespace": *namespace,
+		"process":   "once-cli",
+	})
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	out := newOutput(os.Stdout, *asJson)
+	if err := cmd.run(ctx, out, flags.Args()[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "once %s: %v\n", cmd.name, err)
+		os.Exit(1)
+	}
+}
+
+func usage(flags *flag.FlagSet) {
+	fmt.Fprintf(os.Stderr, "Usage: once [flags] <command> [args]\n\n")
+	fmt.Fprintf(os.Stderr, "Commands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %s %s\n", cmd.name, cmd.usage)
+	}
+	fmt.Fprintf(os.Stderr, "\nFlags:\n")
+	flags.PrintDefaults()
+}
+
+func envOr(name, def string) string {
+	if val := os.Getenv(name); val != "" {
+		return val
+	}
+	return def
+}
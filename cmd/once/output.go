@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	once "github.com/PlanitarInc/go-workers-once"
+)
+
+// output prints the job descriptors either as a table or as JSON, one
+// descriptor per line.
+type output struct {
+	w      io.Writer
+	asJson bool
+}
+
+func newOutput(w io.Writer, asJson bool) *output {
+	return &output{w: w, asJson: asJson}
+}
+
+// Descs prints the descriptors, the table has a header.
+func (o *output) Descs(descs []*once.JobDesc) error {
+	if o.asJson {
+		for _, desc := range descs {
+			if err := o.Desc(desc); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(o.w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "JID\tQUEUE\tJOB TYPE\tSTATUS\tUPDATED\tRESULT")
+	for _, desc := range descs {
+		fmt.Fprintln(tw, descRow(desc))
+	}
+	return tw.Flush()
+}
+
+// Desc prints a single descriptor, the table has no header.
+func (o *output) Desc(desc *once.JobDesc) error {
+	if o.asJson {
+		return json.NewEncoder(o.w).Encode(desc)
+	}
+
+	tw := tabwriter.NewWriter(o.w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, descRow(desc))
+	return tw.Flush()
+}
+
+// Value prints a single value, e.g. a JID.
+func (o *output) Value(name string, v interface{}) error {
+	if o.asJson {
+		return json.NewEncoder(o.w).Encode(map[string]interface{}{name: v})
+	}
+
+	_, err := fmt.Fprintln(o.w, v)
+	return err
+}
+
+func descRow(desc *once.JobDesc) string {
+	jobType := desc.JobType
+	if desc.UniqueKey != "" {
+		jobType += ":" + desc.UniqueKey
+	}
+
+	updated := "-"
+	if desc.UpdatedMs != 0 {
+		updated = desc.UpdatedAt().Format(time.RFC3339)
+	}
+
+	return fmt.Sprintf("%s\t%s\t%s\t%s\t%s\t%s", desc.Jid, desc.Queue, jobType,
+		desc.Status, updated, desc.Result)
+}
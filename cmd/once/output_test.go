@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	once "github.com/PlanitarInc/go-workers-once"
+	. "github.com/onsi/gomega"
+)
+
+func TestOutput_Table(t *testing.T) {
+	RegisterTestingT(t)
+
+	buf := &bytes.Buffer{}
+	out := newOutput(buf, false)
+
+	Ω(out.Descs([]*once.JobDesc{
+		{Jid: "1", Queue: "q", JobType: "add", UniqueKey: "abc",
+			Status: once.StatusFailed, Result: "boom"},
+		{Jid: "22", Queue: "q", JobType: "sub", Status: once.StatusOK},
+	})).Should(BeNil())
+
+	Ω(buf.String()).Should(Equal(
+		"JID  QUEUE  JOB TYPE  STATUS  UPDATED  RESULT\n" +
+			"1    q      add:abc   failed  -        boom\n" +
+			"22   q      sub       ok      -        \n"))
+}
+
+func TestOutput_Json(t *testing.T) {
+	RegisterTestingT(t)
+
+	buf := &bytes.Buffer{}
+	out := newOutput(buf, true)
+
+	Ω(out.Descs([]*once.JobDesc{
+		{Jid: "1", Status: once.StatusOK},
+		{Jid: "2", Status: once.StatusOK},
+	})).Should(BeNil())
+	Ω(out.Value("jid", "3")).Should(BeNil())
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	Ω(lines).Should(HaveLen(3))
+	Ω(lines[0]).Should(MatchJSON(`{
+		"jid": "1",
+		"status": "ok",
+		"queue": "",
+		"job_type": "",
+		"created_ms": 0,
+		"updated_ms": 0,
+		"options": null,
+		"result": ""
+	}`))
+	Ω(lines[2]).Should(MatchJSON(`{"jid": "3"}`))
+}
+
+func TestJobFlags(t *testing.T) {
+	RegisterTestingT(t)
+
+	{
+		f := newJobFlags("get")
+		Ω(f.Parse([]string{"-args", `[1,"a"]`, "q", "add"})).Should(BeNil())
+		Ω(f.queue).Should(Equal("q"))
+		Ω(f.jobType).Should(Equal("add"))
+
+		args, err := f.jobArgs()
+		Ω(err).Should(BeNil())
+		Ω(args).Should(Equal([]interface{}{1.0, "a"}))
+	}
+
+	{
+		f := newJobFlags("get")
+		Ω(f.Parse([]string{"q"})).ShouldNot(BeNil())
+	}
+
+	{
+		f := newJobFlags("get")
+		Ω(f.Parse([]string{"-args", "[", "q", "add"})).Should(BeNil())
+		_, err := f.jobArgs()
+		Ω(err).ShouldNot(BeNil())
+	}
+}
+
+func TestFilterFlags(t *testing.T) {
+	RegisterTestingT(t)
+
+	f := newFilterFlags("list")
+	Ω(f.Parse([]string{
+		"-queue", "q",
+		"-status", "executing,retry-waiting",
+		"-min-age", "1h",
+	})).Should(BeNil())
+	Ω(f.filter.Queue).Should(Equal("q"))
+	Ω(f.filter.Statuses).Should(Equal([]string{
+		once.StatusExecuting,
+		once.StatusRetryWaiting,
+	}))
+	Ω(f.filter.MinAge.Hours()).Should(Equal(1.0))
+	Ω(f.filter.Limit).Should(Equal(100))
+}
@@ -0,0 +1,23 @@
+-- KEYS:
+--  [1] key of the job descriptor
+-- ARGUMENTS:
+--  [1] Expected JID
+--
+--  Return values:
+--    0  in case of success
+--   -1  if the key does not exist
+--   -2  if the JID is wrong
+
+local val = redis.call("GET", KEYS[1])
+
+if val == false then
+  return -1
+end
+
+val = cjson.decode(val)
+if val["jid"] ~= ARGV[1] then
+  return -2
+end
+
+redis.call("DEL", KEYS[1])
+return 0
@@ -1,7 +1,8 @@
 package once
 
 import (
-	"encoding/json"
+	"context"
+	"math"
 	"time"
 
 	"github.com/PlanitarInc/go-workers"
@@ -20,10 +21,19 @@ func Enqueue(
 	args interface{},
 	opts *Options,
 ) (string, error) {
-	return enqueueJobDesc(
-		NewJobDesc(generateJid(), queue, jobType, opts),
-		args,
-	)
+	return defaultClient().Enqueue(queue, jobType, args, opts)
+}
+
+// EnqueueContext is like Enqueue but uses the given context for obtaining a
+// Redis connection and gives up before enqueuing the task if the context is
+// done.
+func EnqueueContext(
+	ctx context.Context,
+	queue, jobType string,
+	args interface{},
+	opts *Options,
+) (string, error) {
+	return defaultClient().EnqueueContext(ctx, queue, jobType, args, opts)
 }
 
 // Enqueue schedules the given task to the given queue with the given delay, if
@@ -38,86 +48,303 @@ func EnqueueIn(
 	args interface{},
 	opts *Options,
 ) (string, error) {
+	return defaultClient().EnqueueIn(queue, jobType, in, args, opts)
+}
+
+// EnqueueInContext is like EnqueueIn but respects the given context, see
+// EnqueueContext.
+func EnqueueInContext(
+	ctx context.Context,
+	queue, jobType string,
+	in time.Duration,
+	args interface{},
+	opts *Options,
+) (string, error) {
+	return defaultClient().EnqueueInContext(ctx, queue, jobType, in, args, opts)
+}
+
+// Enqueue schedules the given task to the given queue. If a task of the same
+// type is already scheduled, the given task will get scheduled anyway
+// overriding the previous one.
+func EnqueueForce(
+	queue, jobType string,
+	args interface{},
+	opts *Options,
+) (string, error) {
+	return defaultClient().EnqueueForce(queue, jobType, args, opts)
+}
+
+// EnqueueForceContext is like EnqueueForce but respects the given context,
+// see EnqueueContext.
+func EnqueueForceContext(
+	ctx context.Context,
+	queue, jobType string,
+	args interface{},
+	opts *Options,
+) (string, error) {
+	return defaultClient().EnqueueForceContext(ctx, queue, jobType, args, opts)
+}
+
+// Enqueue schedules the given task to the given queue with the given delay.
+// If a task of the same type is already scheduled, the given task will get
+// scheduled anyway overriding the previous one.
+func EnqueueForceIn(
+	queue, jobType string,
+	in time.Duration,
+	args interface{},
+	opts *Options,
+) (string, error) {
+	return defaultClient().EnqueueForceIn(queue, jobType, in, args, opts)
+}
+
+// EnqueueForceInContext is like EnqueueForceIn but respects the given
+// context, see EnqueueContext.
+func EnqueueForceInContext(
+	ctx context.Context,
+	queue, jobType string,
+	in time.Duration,
+	args interface{},
+	opts *Options,
+) (string, error) {
+	return defaultClient().EnqueueForceInContext(ctx,
+		queue, jobType, in, args, opts)
+}
+
+// Enqueue is the client's counterpart of the package-level Enqueue.
+func (c *Client) Enqueue(
+	queue, jobType string,
+	args interface{},
+	opts *Options,
+) (string, error) {
+	return c.EnqueueContext(context.Background(), queue, jobType, args, opts)
+}
+
+// EnqueueContext is the client's counterpart of the package-level
+// EnqueueContext.
+func (c *Client) EnqueueContext(
+	ctx context.Context,
+	queue, jobType string,
+	args interface{},
+	opts *Options,
+) (string, error) {
+	return c.enqueueJobDesc(ctx,
+		NewJobDesc(generateJid(), queue, jobType, c.options(opts)),
+		args,
+	)
+}
+
+// EnqueueIn is the client's counterpart of the package-level EnqueueIn.
+func (c *Client) EnqueueIn(
+	queue, jobType string,
+	in time.Duration,
+	args interface{},
+	opts *Options,
+) (string, error) {
+	return c.EnqueueInContext(context.Background(),
+		queue, jobType, in, args, opts)
+}
+
+// EnqueueInContext is the client's counterpart of the package-level
+// EnqueueInContext.
+func (c *Client) EnqueueInContext(
+	ctx context.Context,
+	queue, jobType string,
+	in time.Duration,
+	args interface{},
+	opts *Options,
+) (string, error) {
+	opts = c.options(opts)
 	if opts == nil {
 		opts = &Options{}
 	}
 	opts.At = workers.NowToSecondsWithNanoPrecision() + in.Seconds()
 
-	return enqueueJobDesc(
+	return c.enqueueJobDesc(ctx,
 		NewJobDesc(generateJid(), queue, jobType, opts),
 		args,
 	)
 }
 
-// Enqueue schedules the given task to the given queue. If a task of the same
-// type is already scheduled, the given task will get scheduled anyway
-// overriding the previous one.
-func EnqueueForce(
+// EnqueueForce is the client's counterpart of the package-level
+// EnqueueForce.
+func (c *Client) EnqueueForce(
 	queue, jobType string,
 	args interface{},
 	opts *Options,
 ) (string, error) {
-	return enqueueJobDesc(
-		NewJobDesc(generateJid(), queue, jobType, opts),
+	return c.EnqueueForceContext(context.Background(),
+		queue, jobType, args, opts)
+}
+
+// EnqueueForceContext is the client's counterpart of the package-level
+// EnqueueForceContext.
+func (c *Client) EnqueueForceContext(
+	ctx context.Context,
+	queue, jobType string,
+	args interface{},
+	opts *Options,
+) (string, error) {
+	return c.enqueueJobDesc(ctx,
+		NewJobDesc(generateJid(), queue, jobType, c.options(opts)),
 		args,
 		true,
 	)
 }
 
-// Enqueue schedules the given task to the given queue with the given delay.
-// If a task of the same type is already scheduled, the given task will get
-// scheduled anyway overriding the previous one.
-func EnqueueForceIn(
+// EnqueueForceIn is the client's counterpart of the package-level
+// EnqueueForceIn.
+func (c *Client) EnqueueForceIn(
 	queue, jobType string,
 	in time.Duration,
 	args interface{},
 	opts *Options,
 ) (string, error) {
+	return c.EnqueueForceInContext(context.Background(),
+		queue, jobType, in, args, opts)
+}
+
+// EnqueueForceInContext is the client's counterpart of the package-level
+// EnqueueForceInContext.
+func (c *Client) EnqueueForceInContext(
+	ctx context.Context,
+	queue, jobType string,
+	in time.Duration,
+	args interface{},
+	opts *Options,
+) (string, error) {
+	opts = c.options(opts)
 	if opts == nil {
 		opts = &Options{}
 	}
 	opts.At = workers.NowToSecondsWithNanoPrecision() + in.Seconds()
 
-	return enqueueJobDesc(
+	return c.enqueueJobDesc(ctx,
 		NewJobDesc(generateJid(), queue, jobType, opts),
 		args,
 		true,
 	)
 }
 
-func enqueueJobDesc(desc *JobDesc, args interface{}, override ...bool) (string, error) {
-	conn := workers.Config.Pool.Get()
-	defer conn.Close()
+func (c *Client) enqueueJobDesc(
+	ctx context.Context,
+	desc *JobDesc,
+	args interface{},
+	override ...bool,
+) (string, error) {
+	force := len(override) > 0 && override[0]
+
+	jid, replaced, err := c.createJobDesc(ctx, desc, args, force)
+	outcome := EnqueueCreated
+	switch {
+	case err != nil:
+		outcome = EnqueueFailed
+	case jid != desc.Jid:
+		outcome = EnqueueDeduped
+	case replaced:
+		outcome = EnqueueOverridden
+	}
+	c.metrics().JobEnqueued(desc.Queue, desc.JobType, outcome)
 
-	key := workers.Config.Namespace + "once:q:" + desc.Queue + ":" + desc.JobType
+	return jid, err
+}
+
+// createJobDesc stores the descriptor and enqueues the job, unless there is
+// another job of the same type to return instead. Returns whether the job
+// replaced another one.
+func (c *Client) createJobDesc(
+	ctx context.Context,
+	desc *JobDesc,
+	args interface{},
+	force bool,
+) (string, bool, error) {
+	if desc.Options.UniqueByArgs {
+		uniqueKey, err := c.uniqueKey(desc.JobType, args)
+		if err != nil {
+			return "", false, err
+		}
+		desc.UniqueKey = uniqueKey
+	}
+
+	store := c.store()
+	key := c.key(desc.Queue, desc.JobType, desc.UniqueKey)
 
 	msg := workers.PrepareEnqueuMsg(desc.Queue, "", args,
 		desc.Options.EnqueueOptions)
 	msg.Set("jid", desc.Jid)
 	msg.Set("x-once", desc)
 
-	descJson, _ := msg.Get("x-once").MarshalJSON()
-	if len(override) > 0 && override[0] {
-		err := setNewJobDesc(conn, key, desc.Options.InitWaitTime, descJson)
+	debounce := desc.Options.Debounce && !force
+	expire := desc.Options.InitWaitTime
+	if debounce && desc.Options.At > 0 {
+		desc.RunAtMs = int64(desc.Options.At * 1000)
+		expire = debounceExpire(desc.Options, desc.RunAt())
+	}
+
+	// Retry if the job being postponed has just started (or was removed)
+	replaced := false
+	for attempt := 1; ; attempt++ {
+		var other *JobDesc
+		var err error
+		other, replaced, err = store.Create(ctx, key, desc, expire, force)
 		if err != nil {
-			return "", err
+			return "", false, err
+		} else if other == nil {
+			break
+		} else if other.Jid == desc.Jid {
+			// The job is deferred to the end of the throttling window of
+			// the job succeeded recently.
+			desc.RunAtMs = other.RunAtMs
+			msg.Set("at", float64(other.RunAtMs)/1000)
+			break
+		} else if !debounce || !other.IsDebounced() || desc.RunAtMs == 0 {
+			return other.Jid, false, nil
 		}
-	} else {
-		other, err := trySetNewDescJob(conn, key, desc.Options.InitWaitTime, descJson)
+
+		runAt := desc.RunAt()
+		if maxWait := other.Options.DebounceMaxWait; maxWait > 0 {
+			deadline := other.CreatedAt().Add(time.Duration(maxWait) * time.Second)
+			if runAt.After(deadline) {
+				runAt = deadline
+			}
+		}
+
+		n, err := store.Postpone(ctx, key, other.Jid, runAt,
+			debounceExpire(other.Options, runAt))
 		if err != nil {
-			return "", err
-		} else if other != nil {
-			return other.Jid, nil
+			return "", false, err
+		} else if n == 0 || attempt >= maxPostponeAttempts {
+			return other.Jid, false, nil
 		}
 	}
 
-	err := workers.EnqueueMsg(msg)
+	// The descriptor is already stored, make sure it does not block other
+	// tasks of the same type if the caller is gone.
+	if err := ctx.Err(); err != nil {
+		store.Delete(context.Background(), key, desc.Jid)
+		return "", false, err
+	}
+
+	err := c.enqueueMsg(ctx, msg)
 	if err != nil {
-		unsetJobDesc(conn, key, desc.Jid)
-		return "", err
+		store.Delete(context.Background(), key, desc.Jid)
+		return "", false, err
+	}
+
+	return desc.Jid, replaced, nil
+}
+
+const maxPostponeAttempts = 3
+
+// debounceExpire returns the expiration time of the descriptor of the job
+// postponed to the given time: the job is given InitWaitTime to start after
+// that time.
+func debounceExpire(opts *Options, runAt time.Time) int {
+	expire := opts.InitWaitTime
+	if delay := time.Until(runAt); delay > 0 {
+		expire += int(math.Ceil(delay.Seconds()))
 	}
 
-	return desc.Jid, nil
+	return expire
 }
 
 func setNewJobDesc(
@@ -126,7 +353,7 @@ func setNewJobDesc(
 	expire int,
 	descJson []byte,
 ) error {
-	_, err := redis.String(conn.Do("SET", key, descJson, "EX", expire))
+	_, _, err := setJobDesc(conn, key, expire, descJson, true, time.Now())
 	return err
 }
 
@@ -136,54 +363,13 @@ func trySetNewDescJob(
 	expire int,
 	descJson []byte,
 ) (*JobDesc, error) {
-	// Enqueue retry loop
-	for {
-		// Check if a job of the same type is already scheduled
-		otherDescJson, err := redis.Bytes(conn.Do("GET", key))
-		if err != nil && err != redis.ErrNil {
-			return nil, err
-		}
-
-		// There is a scheduled job, inspect it
-		if err == nil {
-			otherDesc := JobDesc{}
-			err := json.Unmarshal(otherDescJson, &otherDesc)
-			if err == nil && !otherDesc.CanBeOverridden() {
-				return &otherDesc, err
-			}
-
-			// Either the job descriptor is bad, or it was already executed at
-			// least once -- reschedule
-			_, err = redis.String(conn.Do("SET", key, descJson, "EX", expire, "XX"))
-			if err != nil && err != redis.ErrNil {
-				return nil, err
-			}
-
-			if err == nil {
-				return nil, nil
-			}
-
-			// retry
-			continue
-		}
-
-		// No job is scheduled, try to add a new one
-		_, err = redis.String(conn.Do("SET", key, descJson, "EX", expire, "NX"))
-		if err != nil && err != redis.ErrNil {
-			return nil, err
-		}
-
-		if err == nil {
-			return nil, nil
-		}
-
-		// retry
-	}
+	desc, _, err := setJobDesc(conn, key, expire, descJson, false, time.Now())
+	return desc, err
 }
 
 func unsetJobDesc(conn redis.Conn, key, jid string) error {
-	// The script will set expires to 0 only if the JID value matches.
-	_, err := updateJobStatus(conn, key, jid, "", 0)
+	// The script removes the descriptor only if the JID value matches.
+	_, err := deleteJob(conn, key, jid)
 	return err
 }
 
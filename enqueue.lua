@@ -0,0 +1,89 @@
+-- KEYS:
+--  [1] key of the job descriptor
+-- ARGUMENTS:
+--  [1] New job descriptor (JSON)
+--  [2] Expiration time for the new job descriptor
+--  [3] "1" to store the new descriptor regardless of the existing one
+--  [4] Current timestamp (in ms)
+--
+--  Return values:
+--    "created"  if the new descriptor was stored
+--    "replaced" if the new descriptor was stored in place of another one
+--    the stored job descriptor (JSON) if the new job was deferred to the
+--      end of the throttling window of the existing job
+--    the existing job descriptor (JSON) otherwise
+--
+-- The stored descriptor is published (with the "created" event), so the
+-- waiters of the replaced job learn about it.
+
+local function publishCreated(desc)
+  desc["event"] = "created"
+  redis.call("PUBLISH", KEYS[1], cjson.encode(desc))
+end
+
+-- If OverrideStarted is set, the job already started can be overridden.
+-- Otherwise we have to wait until the job is removed from Redis. A cancelled
+-- job can always be overridden.
+local function canBeOverridden(desc)
+  if desc["status"] == "cancelled" then
+    return true
+  end
+
+  local opts = desc["options"]
+  return type(opts) == "table" and opts["override_started"] == true and
+    desc["status"] ~= "init-waiting"
+end
+
+-- Returns the end (in ms) of the throttling window of the job succeeded
+-- recently, nil if the job is not throttled.
+local function throttledUntil(desc, nowMs)
+  local opts = desc["options"]
+  if type(opts) ~= "table" or desc["status"] ~= "ok" then
+    return nil
+  end
+
+  local window = (tonumber(opts["throttle_window"]) or 0) * 1000
+  local windowEnd = (tonumber(desc["updated_ms"]) or 0) + window
+  if window > 0 and windowEnd > nowMs then
+    return windowEnd
+  end
+  return nil
+end
+
+local val = redis.call("GET", KEYS[1])
+
+if ARGV[3] ~= "1" then
+  if val ~= false then
+    local ok, desc = pcall(cjson.decode, val)
+    -- A bad job descriptor is overridden
+    if ok and type(desc) == "table" then
+      local nowMs = tonumber(ARGV[4])
+      local windowEnd = throttledUntil(desc, nowMs)
+
+      if windowEnd ~= nil then
+        if desc["options"]["throttle_defer"] ~= true then
+          return val
+        end
+
+        local newDesc = cjson.decode(ARGV[1])
+        newDesc["run_at_ms"] = windowEnd
+        local newVal = cjson.encode(newDesc)
+        local expire = tonumber(ARGV[2]) + math.ceil((windowEnd - nowMs) / 1000)
+        redis.call("SET", KEYS[1], newVal, "EX", expire)
+        publishCreated(newDesc)
+        return newVal
+      end
+
+      if not canBeOverridden(desc) then
+        return val
+      end
+    end
+  end
+end
+
+redis.call("SET", KEYS[1], ARGV[1], "EX", ARGV[2])
+publishCreated(cjson.decode(ARGV[1]))
+if val ~= false then
+  return "replaced"
+end
+return "created"
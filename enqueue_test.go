@@ -1,8 +1,12 @@
 package once
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
+	"strconv"
 	"testing"
+	"time"
 
 	"github.com/PlanitarInc/go-workers"
 	"github.com/bitly/go-simplejson"
@@ -423,7 +427,7 @@ func TestEnqueueJobDesc(t *testing.T) {
 	}
 
 	{
-		jid, err := enqueueJobDesc(desc, nil)
+		jid, err := defaultClient().enqueueJobDesc(context.Background(), desc, nil)
 		Ω(err).Should(BeNil())
 		Ω(jid).Should(Equal("1"))
 	}
@@ -483,7 +487,7 @@ func TestEnqueueJobDesc_PreexistsInitWaiting(t *testing.T) {
 	}
 
 	{
-		jid, err := enqueueJobDesc(desc, nil)
+		jid, err := defaultClient().enqueueJobDesc(context.Background(), desc, nil)
 		Ω(err).Should(BeNil())
 		Ω(jid).Should(Equal("123"))
 	}
@@ -531,7 +535,7 @@ func TestEnqueueJobDesc_PreexistsExecuting(t *testing.T) {
 		}
 
 		{
-			jid, err := enqueueJobDesc(desc, nil)
+			jid, err := defaultClient().enqueueJobDesc(context.Background(), desc, nil)
 			Ω(err).Should(BeNil())
 			Ω(jid).Should(Equal("123"))
 		}
@@ -571,7 +575,7 @@ func TestEnqueueJobDesc_PreexistsExecuting(t *testing.T) {
 		}
 
 		{
-			jid, err := enqueueJobDesc(desc, nil)
+			jid, err := defaultClient().enqueueJobDesc(context.Background(), desc, nil)
 			Ω(err).Should(BeNil())
 			Ω(jid).Should(Equal("3"))
 		}
@@ -633,7 +637,7 @@ func TestEnqueueJobDesc_Overrides(t *testing.T) {
 	}
 
 	{
-		jid, err := enqueueJobDesc(desc, nil, true)
+		jid, err := defaultClient().enqueueJobDesc(context.Background(), desc, nil, true)
 		Ω(err).Should(BeNil())
 		Ω(jid).Should(Equal("3"))
 	}
@@ -698,7 +702,7 @@ func TestEnqueueJobDesc_WithOptions(t *testing.T) {
 	}
 
 	{
-		jid, err := enqueueJobDesc(desc, nil)
+		jid, err := defaultClient().enqueueJobDesc(context.Background(), desc, nil)
 		Ω(err).Should(BeNil())
 		Ω(jid).Should(Equal("5"))
 	}
@@ -743,3 +747,342 @@ func TestEnqueueJobDesc_WithOptions(t *testing.T) {
 		}`))
 	}
 }
+
+func TestEnqueueContext_Cancelled(t *testing.T) {
+	RegisterTestingT(t)
+
+	setupRedis()
+	defer cleanRedis()
+
+	conn := workers.Config.Pool.Get()
+	defer conn.Close()
+
+	key := workers.Config.Namespace + "once:q:tor-cancelled:typo"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	{
+		jid, err := EnqueueContext(ctx, "tor-cancelled", "typo", nil, nil)
+		Ω(err).Should(Equal(context.Canceled))
+		Ω(jid).Should(BeEmpty())
+	}
+
+	{
+		_, err := redis.String(conn.Do("GET", key))
+		Ω(err).Should(Equal(redis.ErrNil))
+	}
+
+	{
+		queue := workers.Config.Namespace + "queue:tor-cancelled"
+		n, err := redis.Int(conn.Do("llen", queue))
+		Ω(err).Should(BeNil())
+		Ω(n).Should(Equal(0))
+	}
+}
+
+func TestEnqueue_UniqueByArgs(t *testing.T) {
+	RegisterTestingT(t)
+
+	setupRedis()
+	defer cleanRedis()
+
+	conn := workers.Config.Pool.Get()
+	defer conn.Close()
+
+	opts := func() *Options {
+		return &Options{UniqueByArgs: true}
+	}
+
+	jid1, err := Enqueue("tor-unique", "add", []int{1, 2}, opts())
+	Ω(err).Should(BeNil())
+
+	{
+		jid, err := Enqueue("tor-unique", "add", []int{1, 2}, opts())
+		Ω(err).Should(BeNil())
+		Ω(jid).Should(Equal(jid1))
+	}
+
+	jid2, err := Enqueue("tor-unique", "add", []int{2, 2}, opts())
+	Ω(err).Should(BeNil())
+	Ω(jid2).ShouldNot(Equal(jid1))
+
+	{
+		queue := workers.Config.Namespace + "queue:tor-unique"
+		n, err := redis.Int(conn.Do("llen", queue))
+		Ω(err).Should(BeNil())
+		Ω(n).Should(Equal(2))
+	}
+
+	{
+		desc, err := GetDesc("tor-unique", "add", []int{1, 2})
+		Ω(err).Should(BeNil())
+		Ω(desc.Jid).Should(Equal(jid1))
+
+		uniqueKey, _ := HashArgs("add", []int{1, 2})
+		Ω(desc.UniqueKey).Should(Equal(uniqueKey))
+	}
+
+	{
+		desc, err := GetDesc("tor-unique", "add", []int{2, 2})
+		Ω(err).Should(BeNil())
+		Ω(desc.Jid).Should(Equal(jid2))
+	}
+
+	{
+		desc, err := GetDesc("tor-unique", "add")
+		Ω(err).Should(Equal(NoMatchingJobsErr))
+		Ω(desc).Should(BeNil())
+	}
+
+	{
+		queue := workers.Config.Namespace + "queue:tor-unique"
+		bs, err := redis.String(conn.Do("lpop", queue))
+		Ω(err).Should(BeNil())
+
+		msg, err := workers.NewMsg(bs)
+		Ω(err).Should(BeNil())
+
+		m := Middleware{}
+		counter, noopNext := getCountableCb()
+		ack := m.Call("tor-unique", msg, noopNext)
+		Ω(ack).Should(BeTrue())
+		Ω(*counter).Should(Equal(1))
+	}
+
+	{
+		desc, err := WaitForJobType("tor-unique", "add", WaitOptions{
+			Args:    []int{1, 2},
+			Timeout: time.Second,
+		})
+		Ω(err).Should(BeNil())
+		Ω(desc.Jid).Should(Equal(jid1))
+		Ω(desc.Status).Should(Equal(StatusOK))
+	}
+}
+
+func TestEnqueue_UniqueKeyFunc(t *testing.T) {
+	RegisterTestingT(t)
+
+	setupRedis()
+	defer cleanRedis()
+
+	c := NewClient(workers.Config.Pool, workers.Config.Namespace)
+	c.UniqueKeyFunc = func(jobType string, args interface{}) (string, error) {
+		return fmt.Sprintf("%s-%v", jobType, args.([]int)[0]), nil
+	}
+	opts := &Options{UniqueByArgs: true}
+
+	jid1, err := c.Enqueue("tor-unique-func", "add", []int{1, 2}, opts)
+	Ω(err).Should(BeNil())
+
+	{
+		jid, err := c.Enqueue("tor-unique-func", "add", []int{1, 3}, opts)
+		Ω(err).Should(BeNil())
+		Ω(jid).Should(Equal(jid1))
+	}
+
+	{
+		desc, err := c.GetDesc("tor-unique-func", "add", []int{1, 4})
+		Ω(err).Should(BeNil())
+		Ω(desc.Jid).Should(Equal(jid1))
+		Ω(desc.UniqueKey).Should(Equal("add-1"))
+	}
+}
+
+func TestEnqueueIn_Debounce(t *testing.T) {
+	RegisterTestingT(t)
+
+	setupRedis()
+	defer cleanRedis()
+
+	conn := workers.Config.Pool.Get()
+	defer conn.Close()
+
+	key := workers.Config.Namespace + "once:q:tor-debounce:typo"
+	schedule := workers.Config.Namespace + workers.SCHEDULED_JOBS_KEY
+
+	jid, err := EnqueueIn("tor-debounce", "typo", time.Minute, nil,
+		&Options{Debounce: true})
+	Ω(err).Should(BeNil())
+
+	{
+		desc, err := GetDesc("tor-debounce", "typo")
+		Ω(err).Should(BeNil())
+		nowMs := time2ms(time.Now())
+		Ω(desc.RunAtMs).Should(BeBetween(nowMs+59900, nowMs+60100))
+	}
+
+	{
+		res, err := redis.Int(conn.Do("TTL", key))
+		Ω(err).Should(BeNil())
+		Ω(res).Should(BeNumerically("~", 90, 1))
+	}
+
+	{
+		other, err := EnqueueIn("tor-debounce", "typo", 2*time.Minute, nil,
+			&Options{Debounce: true})
+		Ω(err).Should(BeNil())
+		Ω(other).Should(Equal(jid))
+	}
+
+	{
+		desc, err := GetDesc("tor-debounce", "typo")
+		Ω(err).Should(BeNil())
+		Ω(desc.Status).Should(Equal(StatusInitWaiting))
+		nowMs := time2ms(time.Now())
+		Ω(desc.RunAtMs).Should(BeBetween(nowMs+119900, nowMs+120100))
+	}
+
+	{
+		res, err := redis.Int(conn.Do("TTL", key))
+		Ω(err).Should(BeNil())
+		Ω(res).Should(BeNumerically("~", 150, 1))
+	}
+
+	{
+		n, err := redis.Int(conn.Do("zcard", schedule))
+		Ω(err).Should(BeNil())
+		Ω(n).Should(Equal(1))
+	}
+}
+
+func TestEnqueueIn_DebounceMaxWait(t *testing.T) {
+	RegisterTestingT(t)
+
+	setupRedis()
+	defer cleanRedis()
+
+	opts := func() *Options {
+		return &Options{Debounce: true, DebounceMaxWait: 90}
+	}
+
+	jid, err := EnqueueIn("tor-debounce-max", "typo", time.Minute, nil, opts())
+	Ω(err).Should(BeNil())
+
+	created, err := GetDesc("tor-debounce-max", "typo")
+	Ω(err).Should(BeNil())
+
+	{
+		other, err := EnqueueIn("tor-debounce-max", "typo", 2*time.Minute, nil,
+			opts())
+		Ω(err).Should(BeNil())
+		Ω(other).Should(Equal(jid))
+	}
+
+	{
+		desc, err := GetDesc("tor-debounce-max", "typo")
+		Ω(err).Should(BeNil())
+		Ω(desc.RunAtMs).Should(Equal(created.CreatedMs + 90000))
+	}
+}
+
+func TestEnqueueIn_DebounceStarted(t *testing.T) {
+	RegisterTestingT(t)
+
+	setupRedis()
+	defer cleanRedis()
+
+	conn := workers.Config.Pool.Get()
+	defer conn.Close()
+
+	key := workers.Config.Namespace + "once:q:tor-debounce-started:typo"
+	oldval := `{"jid":"123","status":"executing","options":{"debounce":true}}`
+
+	{
+		res, err := redis.String(conn.Do("SET", key, oldval))
+		Ω(err).Should(BeNil())
+		Ω(res).Should(Equal("OK"))
+	}
+
+	{
+		jid, err := EnqueueIn("tor-debounce-started", "typo", time.Minute, nil,
+			&Options{Debounce: true})
+		Ω(err).Should(BeNil())
+		Ω(jid).Should(Equal("123"))
+	}
+
+	{
+		res, err := redis.String(conn.Do("GET", key))
+		Ω(err).Should(BeNil())
+		Ω(res).Should(Equal(oldval))
+	}
+}
+
+func TestEnqueue_Throttle(t *testing.T) {
+	RegisterTestingT(t)
+
+	setupRedis()
+	defer cleanRedis()
+
+	conn := workers.Config.Pool.Get()
+	defer conn.Close()
+
+	key := workers.Config.Namespace + "once:q:tor-throttle:typo"
+	queue := workers.Config.Namespace + "queue:tor-throttle"
+	schedule := workers.Config.Namespace + workers.SCHEDULED_JOBS_KEY
+	opts := func() *Options {
+		return &Options{ThrottleWindow: 60, ThrottleDefer: true}
+	}
+
+	jid1, err := Enqueue("tor-throttle", "typo", nil, opts())
+	Ω(err).Should(BeNil())
+
+	{
+		bs, err := redis.String(conn.Do("lpop", queue))
+		Ω(err).Should(BeNil())
+
+		msg, err := workers.NewMsg(bs)
+		Ω(err).Should(BeNil())
+
+		m := Middleware{}
+		counter, noopNext := getCountableCb()
+		ack := m.Call("tor-throttle", msg, noopNext)
+		Ω(ack).Should(BeTrue())
+		Ω(*counter).Should(Equal(1))
+	}
+
+	{
+		// The descriptor is kept for the throttling window
+		res, err := redis.Int(conn.Do("TTL", key))
+		Ω(err).Should(BeNil())
+		Ω(res).Should(Equal(60))
+	}
+
+	okDesc, err := GetDesc("tor-throttle", "typo")
+	Ω(err).Should(BeNil())
+	Ω(okDesc.Jid).Should(Equal(jid1))
+	Ω(okDesc.Status).Should(Equal(StatusOK))
+
+	jid2, err := Enqueue("tor-throttle", "typo", nil, opts())
+	Ω(err).Should(BeNil())
+	Ω(jid2).ShouldNot(Equal(jid1))
+
+	{
+		desc, err := GetDesc("tor-throttle", "typo")
+		Ω(err).Should(BeNil())
+		Ω(desc.Jid).Should(Equal(jid2))
+		Ω(desc.Status).Should(Equal(StatusInitWaiting))
+		Ω(desc.RunAtMs).Should(Equal(okDesc.UpdatedMs + 60000))
+	}
+
+	{
+		res, err := redis.Strings(conn.Do("zrange", schedule, 0, -1, "withscores"))
+		Ω(err).Should(BeNil())
+		Ω(res).Should(HaveLen(2))
+
+		msg, err := workers.NewMsg(res[0])
+		Ω(err).Should(BeNil())
+		Ω(msg.Jid()).Should(Equal(jid2))
+		at, err := strconv.ParseFloat(res[1], 64)
+		Ω(err).Should(BeNil())
+		Ω(at).Should(BeNumerically("~", float64(okDesc.UpdatedMs+60000)/1000, 0.001))
+	}
+
+	{
+		// Deduplicated as usual
+		jid, err := Enqueue("tor-throttle", "typo", nil, opts())
+		Ω(err).Should(BeNil())
+		Ω(jid).Should(Equal(jid2))
+	}
+}
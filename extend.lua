@@ -0,0 +1,29 @@
+-- KEYS:
+--  [1] key of the job descriptor
+-- ARGUMENTS:
+--  [1] Expected JID
+--  [2] New expiration time for the job descriptor
+--
+--  Return values:
+--    0  in case of success
+--   -1  if the key does not exist
+--   -2  if the JID is wrong
+--   -3  if the job is not executing
+
+local val = redis.call("GET", KEYS[1])
+
+if val == false then
+  return -1
+end
+
+val = cjson.decode(val)
+if val["jid"] ~= ARGV[1] then
+  return -2
+end
+
+if val["status"] ~= "executing" then
+  return -3
+end
+
+redis.call("EXPIRE", KEYS[1], ARGV[2])
+return 0
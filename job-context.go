@@ -0,0 +1,44 @@
+package once
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/PlanitarInc/go-workers"
+)
+
+// jobContexts maps the messages being processed by the middleware to their
+// contexts.
+var jobContexts sync.Map
+
+// JobContext returns the context of the once-job being executed. It is done
+// once the job exceeds Options.MaxRuntime or is cancelled, so a cooperative
+// handler can stop early. The job can run after that, the context is only a
+// hint. A non-cancellable context is returned for the other messages.
+func JobContext(message *workers.Msg) context.Context {
+	if ctx, ok := jobContexts.Load(message); ok {
+		return ctx.(context.Context)
+	}
+	return context.Background()
+}
+
+// withJobContext registers the context of the job being executed. The
+// release function has to be called once the job is done.
+func withJobContext(
+	message *workers.Msg,
+	opts *Options,
+) (ctx context.Context, cancel context.CancelFunc, release func()) {
+	if opts.MaxRuntime > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(),
+			time.Duration(opts.MaxRuntime)*time.Second)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+
+	jobContexts.Store(message, ctx)
+	return ctx, cancel, func() {
+		jobContexts.Delete(message)
+		cancel()
+	}
+}
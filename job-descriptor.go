@@ -13,6 +13,10 @@ const (
 	StatusRetryWaiting        = "retry-waiting"
 	StatusOK                  = "ok"
 	StatusFailed              = "failed"
+	StatusCancelled           = "cancelled"
+	// StatusTimedOut is the failed status of the job exceeded
+	// Options.MaxRuntime.
+	StatusTimedOut = "timed-out"
 )
 
 type JobDesc struct {
@@ -23,7 +27,38 @@ type JobDesc struct {
 	CreatedMs int64    `json:"created_ms"`
 	UpdatedMs int64    `json:"updated_ms"`
 	Options   *Options `json:"options"`
-	Result    string   `json:"result"`
+	// Result is the error of the failed job, or the JSON-encoded result of
+	// the succeeded one (see SetResult).
+	Result string `json:"result"`
+	// UniqueKey is derived from the job args if Options.UniqueByArgs is set.
+	UniqueKey string `json:"unique_key,omitempty"`
+	// RunAtMs is the time the debounced job is postponed to.
+	RunAtMs int64 `json:"run_at_ms,omitempty"`
+	// Progress and ProgressMessage are reported by the executing job, see
+	// SetProgress.
+	Progress        float64 `json:"progress,omitempty"`
+	ProgressMessage string  `json:"progress_message,omitempty"`
+	// Attempts are the last MaxAttempts executions of the job, the oldest
+	// first.
+	Attempts []Attempt `json:"attempts,omitempty"`
+}
+
+// MaxAttempts is the number of the executions kept in JobDesc.Attempts.
+const MaxAttempts = 10
+
+// Attempt is an execution of the job.
+type Attempt struct {
+	StartMs int64 `json:"start_ms"`
+	// EndMs is 0 while the attempt is running, or if it was never reported
+	// to end, e.g. the worker crashed.
+	EndMs int64 `json:"end_ms,omitempty"`
+	// Outcome is the status the attempt ended with, e.g. StatusRetryWaiting.
+	Outcome string `json:"outcome,omitempty"`
+	// Error is the error of the failed attempt.
+	Error string `json:"error,omitempty"`
+	// Worker identifies the process that ran the attempt, see
+	// Middleware.WorkerId.
+	Worker string `json:"worker,omitempty"`
 }
 
 type Options struct {
@@ -35,6 +70,32 @@ type Options struct {
 	ExecWaitTime     int  `json:"exec_wait"`
 	SuccessRetention int  `json:"success_retention"`
 	FailureRetention int  `json:"failure_retention"`
+	// UniqueByArgs makes jobs of the same type but with different args
+	// independent of each other, see Client.UniqueKeyFunc.
+	UniqueByArgs bool `json:"unique_by_args,omitempty"`
+	// Debounce makes EnqueueIn postpone the job of the same type that is
+	// still waiting to start, instead of ignoring the new one. The job runs
+	// once the given delay has passed since the last EnqueueIn.
+	Debounce bool `json:"debounce,omitempty"`
+	// DebounceMaxWait limits (in seconds since the job was enqueued first)
+	// how long a debounced job can be postponed. Unlimited if 0.
+	DebounceMaxWait int `json:"debounce_max_wait,omitempty"`
+	// ThrottleWindow (in seconds) makes the job of the same type succeeded
+	// less than the window ago block the new ones, regardless of the other
+	// options. The new jobs are ignored, unless ThrottleDefer is set.
+	ThrottleWindow int `json:"throttle_window,omitempty"`
+	// ThrottleDefer makes the new jobs enqueued within the throttling window
+	// run at the end of the window.
+	ThrottleDefer bool `json:"throttle_defer,omitempty"`
+	// MaxConcurrency limits the number of the jobs of the same type (e.g.
+	// having different args, see UniqueByArgs) executing at the same time.
+	// A job not getting one of the slots is rescheduled with a backoff.
+	// Unlimited if 0.
+	MaxConcurrency int `json:"max_concurrency,omitempty"`
+	// MaxRuntime limits (in seconds) how long the job can execute. The job
+	// running longer is marked timed out and abandoned, see JobContext.
+	// Unlimited if 0.
+	MaxRuntime int `json:"max_runtime,omitempty"`
 }
 
 func optionsFromJson(obj *simplejson.Json) *Options {
@@ -46,6 +107,9 @@ func optionsFromJson(obj *simplejson.Json) *Options {
 	opts.ExecWaitTime, _ = obj.Get("exec_wait").Int()
 	opts.SuccessRetention, _ = obj.Get("success_retention").Int()
 	opts.FailureRetention, _ = obj.Get("failure_retention").Int()
+	opts.ThrottleWindow, _ = obj.Get("throttle_window").Int()
+	opts.MaxConcurrency, _ = obj.Get("max_concurrency").Int()
+	opts.MaxRuntime, _ = obj.Get("max_runtime").Int()
 
 	return optionsMergeDefaults(&opts)
 }
@@ -89,21 +153,50 @@ func NewJobDesc(jid, queue, jobType string, opts *Options) *JobDesc {
 }
 
 func (d JobDesc) CanBeOverridden() bool {
+	if d.IsCancelled() {
+		return true
+	}
+
 	// If OverrideStarted is set, we can override the task already started.
 	// Otherwise we have to wait until the task is removed from Redis.
 	return d.Options != nil && d.Options.OverrideStarted && d.Status != StatusInitWaiting
 }
 
+func (d JobDesc) IsDebounced() bool {
+	return d.Options != nil && d.Options.Debounce && d.IsInitWaiting()
+}
+
+// ThrottledUntil returns the end of the throttling window of the job
+// succeeded recently, see Options.ThrottleWindow.
+func (d JobDesc) ThrottledUntil(now time.Time) (time.Time, bool) {
+	if d.Options == nil || d.Options.ThrottleWindow <= 0 || !d.IsOK() {
+		return time.Time{}, false
+	}
+
+	windowEnd := d.UpdatedAt().Add(
+		time.Duration(d.Options.ThrottleWindow) * time.Second)
+	return windowEnd, windowEnd.After(now)
+}
+
 func (d JobDesc) IsInitWaiting() bool {
 	return d.Status == StatusInitWaiting
 }
 
 func (d JobDesc) IsDone() bool {
-	return d.Status == StatusOK || d.Status == StatusFailed
+	return d.Status == StatusOK || d.IsFailed() || d.Status == StatusCancelled
 }
 
+func (d JobDesc) IsCancelled() bool {
+	return d.Status == StatusCancelled
+}
+
+// IsFailed returns true if the job failed, including timing out.
 func (d JobDesc) IsFailed() bool {
-	return d.Status == StatusFailed
+	return d.Status == StatusFailed || d.Status == StatusTimedOut
+}
+
+func (d JobDesc) IsTimedOut() bool {
+	return d.Status == StatusTimedOut
 }
 
 func (d JobDesc) IsOK() bool {
@@ -118,6 +211,18 @@ func (d JobDesc) UpdatedAt() time.Time {
 	return ms2time(d.UpdatedMs)
 }
 
+func (d JobDesc) RunAt() time.Time {
+	return ms2time(d.RunAtMs)
+}
+
+func (a Attempt) StartedAt() time.Time {
+	return ms2time(a.StartMs)
+}
+
+func (a Attempt) EndedAt() time.Time {
+	return ms2time(a.EndMs)
+}
+
 func time2ms(t time.Time) int64 {
 	return t.UnixNano() / 1e6
 }
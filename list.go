@@ -0,0 +1,127 @@
+package once
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// ListFilter selects the jobs returned by List. The zero value selects all
+// the jobs.
+type ListFilter struct {
+	// Queue, if set, limits the jobs to the given queue.
+	Queue string
+	// JobTypePrefix, if set, limits the jobs to the types starting with it.
+	JobTypePrefix string
+	// Statuses, if not empty, limits the jobs to the given statuses.
+	Statuses []string
+	// MinAge and MaxAge, if set, limit the time passed since the last
+	// update of the jobs. E.g. the jobs executing for longer than expected
+	// are selected by StatusExecuting and MinAge.
+	MinAge time.Duration
+	MaxAge time.Duration
+
+	// Cursor continues the listing from the previous page, see ListPage.
+	Cursor string
+	// Limit is the approximate number of the jobs in a page, 100 by
+	// default.
+	Limit int
+}
+
+// ListPage is a page of the jobs returned by List.
+type ListPage struct {
+	Jobs []*JobDesc
+	// Cursor is passed in ListFilter to get the next page. It is empty once
+	// all the jobs are listed.
+	Cursor string
+}
+
+// List returns a page of the job descriptors matching the filter, in no
+// particular order. The listing is a scan of the namespace and is not a
+// snapshot: the jobs enqueued or updated meanwhile might be missed or
+// returned twice.
+func List(ctx context.Context, filter ListFilter) (*ListPage, error) {
+	return defaultClient().List(ctx, filter)
+}
+
+// List is the client's counterpart of the package-level List.
+func (c *Client) List(
+	ctx context.Context,
+	filter ListFilter,
+) (*ListPage, error) {
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = 100
+	}
+
+	prefix := c.keyPrefix()
+	if filter.Queue != "" {
+		prefix = c.key(filter.Queue, filter.JobTypePrefix, "")
+	}
+
+	now := time.Now()
+	page := &ListPage{Jobs: []*JobDesc{}, Cursor: filter.Cursor}
+
+	for {
+		descs, cursor, err := c.store().Scan(ctx, prefix, page.Cursor, limit)
+		if err != nil {
+			return nil, err
+		}
+		page.Cursor = cursor
+
+		for _, desc := range descs {
+			if filter.matches(desc, now) {
+				page.Jobs = append(page.Jobs, desc)
+			}
+		}
+
+		if page.Cursor == "" || len(page.Jobs) >= limit {
+			return page, nil
+		}
+	}
+}
+
+func (f ListFilter) matches(desc *JobDesc, now time.Time) bool {
+	// The scanned keys are narrowed down by the queue and the job type, but
+	// the prefix of queue "a" matches the keys of queue "a:b" too
+	if f.Queue != "" && desc.Queue != f.Queue {
+		return false
+	}
+	if !strings.HasPrefix(desc.JobType, f.JobTypePrefix) {
+		return false
+	}
+
+	if len(f.Statuses) > 0 {
+		found := false
+		for _, status := range f.Statuses {
+			found = found || desc.Status == status
+		}
+		if !found {
+			return false
+		}
+	}
+
+	age := now.Sub(desc.UpdatedAt())
+	if f.MinAge > 0 && age < f.MinAge {
+		return false
+	}
+	if f.MaxAge > 0 && age > f.MaxAge {
+		return false
+	}
+
+	return true
+}
+
+// Delete removes the descriptor of the given job, e.g. one found by List,
+// so a new job of the same type can be enqueued. Nothing is removed if
+// another job replaced the given one. The job itself is not affected, see
+// Cancel.
+func Delete(ctx context.Context, desc *JobDesc) error {
+	return defaultClient().Delete(ctx, desc)
+}
+
+// Delete is the client's counterpart of the package-level Delete.
+func (c *Client) Delete(ctx context.Context, desc *JobDesc) error {
+	key := c.key(desc.Queue, desc.JobType, desc.UniqueKey)
+	return c.store().Delete(ctx, key, desc.Jid)
+}
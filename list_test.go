@@ -0,0 +1,253 @@
+package once
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/PlanitarInc/go-workers"
+	. "github.com/onsi/gomega"
+)
+
+func jidsOf(descs []*JobDesc) []string {
+	jids := []string{}
+	for _, desc := range descs {
+		jids = append(jids, desc.Jid)
+	}
+	return jids
+}
+
+func TestList(t *testing.T) {
+	RegisterTestingT(t)
+
+	setupRedis()
+	defer cleanRedis()
+
+	ctx := context.Background()
+
+	jid1, err := Enqueue("list-1", "email-welcome", nil, nil)
+	Ω(err).Should(BeNil())
+	jid2, err := Enqueue("list-1", "email-reminder", nil, nil)
+	Ω(err).Should(BeNil())
+	jid3, err := Enqueue("list-1", "sms", nil, nil)
+	Ω(err).Should(BeNil())
+	jid4, err := Enqueue("list-2", "email-welcome", nil, nil)
+	Ω(err).Should(BeNil())
+
+	{
+		// Not a job descriptor, skipped
+		conn := workers.Config.Pool.Get()
+		_, err := conn.Do("SET", defaultClient().key("list-1", "junk", ""),
+			"{")
+		conn.Close()
+		Ω(err).Should(BeNil())
+	}
+
+	store := defaultClient().store()
+	key := defaultClient().key("list-1", "email-reminder", "")
+	_, err = store.UpdateStatus(ctx, key, jid2, StatusExecuting, 30,
+		time.Now().Add(-time.Hour), "", "")
+	Ω(err).Should(BeNil())
+
+	{
+		page, err := List(ctx, ListFilter{})
+		Ω(err).Should(BeNil())
+		Ω(page.Cursor).Should(BeEmpty())
+		Ω(jidsOf(page.Jobs)).Should(ConsistOf(jid1, jid2, jid3, jid4))
+	}
+
+	{
+		page, err := List(ctx, ListFilter{Queue: "list-1"})
+		Ω(err).Should(BeNil())
+		Ω(jidsOf(page.Jobs)).Should(ConsistOf(jid1, jid2, jid3))
+	}
+
+	{
+		page, err := List(ctx, ListFilter{
+			Queue:         "list-1",
+			JobTypePrefix: "email-",
+		})
+		Ω(err).Should(BeNil())
+		Ω(jidsOf(page.Jobs)).Should(ConsistOf(jid1, jid2))
+	}
+
+	{
+		page, err := List(ctx, ListFilter{JobTypePrefix: "email-"})
+		Ω(err).Should(BeNil())
+		Ω(jidsOf(page.Jobs)).Should(ConsistOf(jid1, jid2, jid4))
+	}
+
+	{
+		// The stuck jobs
+		page, err := List(ctx, ListFilter{
+			Statuses: []string{StatusExecuting},
+			MinAge:   10 * time.Minute,
+		})
+		Ω(err).Should(BeNil())
+		Ω(jidsOf(page.Jobs)).Should(ConsistOf(jid2))
+	}
+
+	{
+		page, err := List(ctx, ListFilter{
+			Statuses: []string{StatusInitWaiting, StatusOK},
+			MaxAge:   10 * time.Minute,
+		})
+		Ω(err).Should(BeNil())
+		Ω(jidsOf(page.Jobs)).Should(ConsistOf(jid1, jid3, jid4))
+	}
+}
+
+func TestList_Pages(t *testing.T) {
+	RegisterTestingT(t)
+
+	setupRedis()
+	defer cleanRedis()
+
+	ctx := context.Background()
+
+	jids := []string{}
+	for i := 0; i < 50; i++ {
+		jid, err := Enqueue("list-pages", fmt.Sprintf("job-%d", i), nil, nil)
+		Ω(err).Should(BeNil())
+		jids = append(jids, jid)
+	}
+
+	listed := []string{}
+	filter := ListFilter{Limit: 10}
+	for pages := 1; ; pages++ {
+		Ω(pages).Should(BeNumerically("<=", 50))
+
+		page, err := List(ctx, filter)
+		Ω(err).Should(BeNil())
+		listed = append(listed, jidsOf(page.Jobs)...)
+
+		if page.Cursor == "" {
+			break
+		}
+		filter.Cursor = page.Cursor
+	}
+
+	Ω(listed).Should(ConsistOf(jids))
+}
+
+func TestList_MemoryStore(t *testing.T) {
+	RegisterTestingT(t)
+
+	ctx := context.Background()
+	client := &Client{
+		Store: NewMemoryStore(),
+		EnqueueMsg: func(ctx context.Context, msg *workers.Msg) error {
+			return nil
+		},
+	}
+
+	jids := []string{}
+	for i := 0; i < 5; i++ {
+		jid, err := client.Enqueue("list-mem", fmt.Sprintf("job-%d", i), nil,
+			nil)
+		Ω(err).Should(BeNil())
+		jids = append(jids, jid)
+	}
+
+	page, err := client.List(ctx, ListFilter{Limit: 2})
+	Ω(err).Should(BeNil())
+	Ω(page.Jobs).Should(HaveLen(2))
+	Ω(page.Cursor).ShouldNot(BeEmpty())
+
+	listed := jidsOf(page.Jobs)
+	for page.Cursor != "" {
+		page, err = client.List(ctx, ListFilter{Limit: 2, Cursor: page.Cursor})
+		Ω(err).Should(BeNil())
+		listed = append(listed, jidsOf(page.Jobs)...)
+	}
+	Ω(listed).Should(Equal(jids))
+}
+
+func TestList_NestedQueue(t *testing.T) {
+	RegisterTestingT(t)
+
+	ctx := context.Background()
+	client := &Client{
+		Store: NewMemoryStore(),
+		EnqueueMsg: func(ctx context.Context, msg *workers.Msg) error {
+			return nil
+		},
+	}
+
+	jid, err := client.Enqueue("a", "b:email", nil, nil)
+	Ω(err).Should(BeNil())
+	// Shares the prefix of the keys of queue "a"
+	_, err = client.Enqueue("a:b", "email", nil, nil)
+	Ω(err).Should(BeNil())
+
+	{
+		page, err := client.List(ctx, ListFilter{Queue: "a"})
+		Ω(err).Should(BeNil())
+		Ω(jidsOf(page.Jobs)).Should(Equal([]string{jid}))
+	}
+
+	{
+		page, err := client.List(ctx, ListFilter{
+			Queue:         "a",
+			JobTypePrefix: "b",
+		})
+		Ω(err).Should(BeNil())
+		Ω(jidsOf(page.Jobs)).Should(Equal([]string{jid}))
+	}
+}
+
+func TestDelete(t *testing.T) {
+	RegisterTestingT(t)
+
+	setupRedis()
+	defer cleanRedis()
+
+	ctx := context.Background()
+	opts := &Options{UniqueByArgs: true}
+
+	_, err := Enqueue("delete-1", "email", []int{1}, opts)
+	Ω(err).Should(BeNil())
+	_, err = Enqueue("delete-1", "email", []int{2}, opts)
+	Ω(err).Should(BeNil())
+
+	desc, err := GetDesc("delete-1", "email", []int{1})
+	Ω(err).Should(BeNil())
+
+	{
+		// Another job replaced the listed one
+		newJid, err := EnqueueForce("delete-1", "email", []int{1}, opts)
+		Ω(err).Should(BeNil())
+
+		Ω(Delete(ctx, desc)).Should(BeNil())
+
+		desc, err = GetDesc("delete-1", "email", []int{1})
+		Ω(err).Should(BeNil())
+		Ω(desc.Jid).Should(Equal(newJid))
+	}
+
+	{
+		Ω(Delete(ctx, desc)).Should(BeNil())
+
+		_, err := GetDesc("delete-1", "email", []int{1})
+		Ω(err).Should(Equal(NoMatchingJobsErr))
+
+		// The other job is intact
+		_, err = GetDesc("delete-1", "email", []int{2})
+		Ω(err).Should(BeNil())
+	}
+
+	{
+		// The cancelled jobs are deleted too
+		Ω(Cancel("delete-1", "email", CancelOptions{Args: []int{2}})).
+			Should(BeNil())
+		desc, err := GetDesc("delete-1", "email", []int{2})
+		Ω(err).Should(BeNil())
+		Ω(desc.Status).Should(Equal(StatusCancelled))
+
+		Ω(Delete(ctx, desc)).Should(BeNil())
+
+		_, err = GetDesc("delete-1", "email", []int{2})
+		Ω(err).Should(Equal(NoMatchingJobsErr))
+	}
+}
@@ -0,0 +1,99 @@
+package once
+
+import (
+	"time"
+)
+
+// The outcomes of Enqueue*, see Metrics.JobEnqueued.
+const (
+	// EnqueueCreated means a new job was enqueued.
+	EnqueueCreated = "created"
+	// EnqueueDeduped means the job of the same type already waiting (or
+	// throttled) was returned instead, possibly postponed.
+	EnqueueDeduped = "deduped"
+	// EnqueueOverridden means the job was enqueued replacing the job of the
+	// same type: by EnqueueForce*, or as the other job was cancelled, started
+	// (see Options.OverrideStarted) or throttled (see Options.ThrottleDefer).
+	EnqueueOverridden = "overridden"
+	// EnqueueFailed means an error was returned.
+	EnqueueFailed = "failed"
+)
+
+// The outcomes of the jobs not executed by Middleware, or executed without
+// storing the status, see Metrics.JobFinished.
+const (
+	// OutcomeDropped means the job of Options.AtMostOnce was dropped, its
+	// descriptor being expired or taken over by another job.
+	OutcomeDropped = "dropped"
+	// OutcomeRescheduled means the job was moved to a later time, being
+	// debounced or waiting for a concurrency slot.
+	OutcomeRescheduled = "rescheduled"
+	// OutcomeLeaseLost means the job was executed but its descriptor
+	// expired or was taken over by another job meanwhile, so its final
+	// status was not stored.
+	OutcomeLeaseLost = "lease-lost"
+)
+
+// Metrics receives the measurements of a client and its middleware, e.g. to
+// feed the counters and histograms of a monitoring system. The methods are
+// called synchronously, so they should be fast and safe for concurrent use.
+//
+// The job types are passed without the unique keys of the jobs enqueued with
+// Options.UniqueByArgs, keeping the number of label values low.
+type Metrics interface {
+	// JobEnqueued is called by Enqueue* with one of the Enqueue* outcomes.
+	JobEnqueued(queue, jobType, outcome string)
+	// JobStarted is called when Middleware starts executing a job for the
+	// first time, with the time the job waited to start since it was
+	// enqueued or scheduled to run.
+	JobStarted(queue, jobType string, waited time.Duration)
+	// JobFinished is called when Middleware is done with a job. The outcome
+	// of the executed job is its status (StatusOK, StatusFailed,
+	// StatusRetryWaiting or StatusTimedOut), StatusCancelled if it was
+	// cancelled while running or OutcomeLeaseLost, and the duration is the
+	// time it was executing. The outcome of the job not executed is
+	// StatusCancelled, OutcomeDropped or OutcomeRescheduled, and the
+	// duration is 0.
+	JobFinished(queue, jobType, outcome string, duration time.Duration)
+	// JobAwaited is called when WaitForJobType or WaitForJid returns, with
+	// the time spent waiting and the returned error, if any. WaitAll calls
+	// it for every target, WaitAny for the completed target or, if none
+	// completed, for every target.
+	JobAwaited(queue, jobType string, waited time.Duration, err error)
+}
+
+// SetMetrics sets the metrics of the default client used by the
+// package-level functions and the zero Middleware. It should be called
+// before using them.
+func SetMetrics(metrics Metrics) {
+	defaultClientCache.Lock()
+	defer defaultClientCache.Unlock()
+
+	defaultClientCache.metrics = metrics
+	// Rebuilt on the next use
+	defaultClientCache.client = nil
+}
+
+func (c *Client) metrics() Metrics {
+	if c.Metrics == nil {
+		return noMetrics{}
+	}
+
+	return c.Metrics
+}
+
+type noMetrics struct{}
+
+func (noMetrics) JobEnqueued(queue, jobType, outcome string)             {}
+func (noMetrics) JobStarted(queue, jobType string, waited time.Duration) {}
+func (noMetrics) JobFinished(
+	queue, jobType, outcome string,
+	duration time.Duration,
+) {
+}
+func (noMetrics) JobAwaited(
+	queue, jobType string,
+	waited time.Duration,
+	err error,
+) {
+}
@@ -0,0 +1,250 @@
+package once
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/PlanitarInc/go-workers"
+	. "github.com/onsi/gomega"
+)
+
+type recordedMetrics struct {
+	sync.Mutex
+	events    []string
+	durations []time.Duration
+}
+
+func (m *recordedMetrics) record(event string, d time.Duration) {
+	m.Lock()
+	defer m.Unlock()
+	m.events = append(m.events, event)
+	m.durations = append(m.durations, d)
+}
+
+func (m *recordedMetrics) reset() {
+	m.Lock()
+	defer m.Unlock()
+	m.events, m.durations = nil, nil
+}
+
+func (m *recordedMetrics) JobEnqueued(queue, jobType, outcome string) {
+	m.record(fmt.Sprintf("enqueued %s %s %s", queue, jobType, outcome), 0)
+}
+
+func (m *recordedMetrics) JobStarted(
+	queue, jobType string,
+	waited time.Duration,
+) {
+	m.record(fmt.Sprintf("started %s %s", queue, jobType), waited)
+}
+
+func (m *recordedMetrics) JobFinished(
+	queue, jobType, outcome string,
+	duration time.Duration,
+) {
+	m.record(fmt.Sprintf("finished %s %s %s", queue, jobType, outcome),
+		duration)
+}
+
+func (m *recordedMetrics) JobAwaited(
+	queue, jobType string,
+	waited time.Duration,
+	err error,
+) {
+	m.record(fmt.Sprintf("awaited %s %s %v", queue, jobType, err), waited)
+}
+
+func newMetricsTestClient() (*Client, *recordedMetrics, chan *workers.Msg) {
+	metrics := &recordedMetrics{}
+	msgs := make(chan *workers.Msg, 10)
+	client := &Client{
+		Store: NewMemoryStore(),
+		EnqueueMsg: func(ctx context.Context, msg *workers.Msg) error {
+			msgs <- msg
+			return nil
+		},
+		Metrics: metrics,
+	}
+
+	return client, metrics, msgs
+}
+
+func TestMetrics_Enqueue(t *testing.T) {
+	RegisterTestingT(t)
+
+	client, metrics, _ := newMetricsTestClient()
+	opts := &Options{UniqueByArgs: true}
+
+	_, err := client.Enqueue("q", "add", []int{1}, opts)
+	Ω(err).Should(BeNil())
+	_, err = client.Enqueue("q", "add", []int{1}, opts)
+	Ω(err).Should(BeNil())
+	_, err = client.EnqueueForce("q", "add", []int{1}, opts)
+	Ω(err).Should(BeNil())
+	// Nothing to override
+	_, err = client.EnqueueForce("q", "add", []int{2}, opts)
+	Ω(err).Should(BeNil())
+	// The cancelled job is replaced
+	Ω(client.Cancel("q", "add", CancelOptions{Args: []int{2}})).
+		Should(BeNil())
+	_, err = client.Enqueue("q", "add", []int{2}, opts)
+	Ω(err).Should(BeNil())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = client.EnqueueContext(ctx, "q", "sub", nil, nil)
+	Ω(err).ShouldNot(BeNil())
+
+	Ω(metrics.events).Should(Equal([]string{
+		"enqueued q add created",
+		"enqueued q add deduped",
+		"enqueued q add overridden",
+		"enqueued q add created",
+		"enqueued q add overridden",
+		"enqueued q sub failed",
+	}))
+}
+
+func TestMetrics_Middleware(t *testing.T) {
+	RegisterTestingT(t)
+
+	client, metrics, msgs := newMetricsTestClient()
+	m := client.Middleware()
+
+	{
+		_, err := client.Enqueue("q", "add", nil, nil)
+		Ω(err).Should(BeNil())
+		msg := <-msgs
+		metrics.reset()
+
+		time.Sleep(20 * time.Millisecond)
+		ack := m.Call("q", msg, func() bool {
+			time.Sleep(30 * time.Millisecond)
+			return true
+		})
+		Ω(ack).Should(BeTrue())
+
+		Ω(metrics.events).Should(Equal([]string{
+			"started q add",
+			"finished q add ok",
+		}))
+		Ω(metrics.durations[0]).Should(BeNumerically(">=", 20*time.Millisecond))
+		Ω(metrics.durations[1]).Should(BeNumerically(">=", 30*time.Millisecond))
+	}
+
+	{
+		_, err := client.Enqueue("q", "fail", nil, nil)
+		Ω(err).Should(BeNil())
+		msg := <-msgs
+		msg.Set("retry", false)
+		metrics.reset()
+
+		Ω(func() { m.Call("q", msg, panicNext) }).Should(Panic())
+		Ω(metrics.events).Should(Equal([]string{
+			"started q fail",
+			"finished q fail failed",
+		}))
+	}
+
+	{
+		_, err := client.Enqueue("q", "cancel", nil, nil)
+		Ω(err).Should(BeNil())
+		msg := <-msgs
+		Ω(client.Cancel("q", "cancel")).Should(BeNil())
+		metrics.reset()
+
+		counter, next := getCountableCb()
+		Ω(m.Call("q", msg, next)).Should(BeTrue())
+		Ω(*counter).Should(Equal(0))
+		Ω(metrics.events).Should(Equal([]string{
+			"finished q cancel cancelled",
+		}))
+		Ω(metrics.durations[0]).Should(BeZero())
+	}
+
+	{
+		_, err := client.Enqueue("q", "drop", nil, &Options{AtMostOnce: true})
+		Ω(err).Should(BeNil())
+		msg := <-msgs
+		_, err = client.EnqueueForce("q", "drop", nil, nil)
+		Ω(err).Should(BeNil())
+		<-msgs
+		metrics.reset()
+
+		counter, next := getCountableCb()
+		Ω(m.Call("q", msg, next)).Should(BeTrue())
+		Ω(*counter).Should(Equal(0))
+		Ω(metrics.events).Should(Equal([]string{
+			"finished q drop dropped",
+		}))
+	}
+}
+
+func TestMetrics_Wait(t *testing.T) {
+	RegisterTestingT(t)
+
+	client, metrics, _ := newMetricsTestClient()
+
+	_, err := client.Enqueue("q", "add", nil, nil)
+	Ω(err).Should(BeNil())
+	metrics.reset()
+
+	_, err = client.WaitForJobType("q", "add", WaitOptions{
+		Timeout: 20 * time.Millisecond,
+	})
+	Ω(err).Should(Equal(TimeoutErr))
+
+	Ω(metrics.events).Should(Equal([]string{"awaited q add timeout"}))
+	Ω(metrics.durations[0]).Should(BeNumerically(">=", 20*time.Millisecond))
+}
+
+func TestMetrics_WaitMany(t *testing.T) {
+	RegisterTestingT(t)
+
+	client, metrics, _ := newMetricsTestClient()
+
+	_, err := client.Enqueue("q", "add", nil, nil)
+	Ω(err).Should(BeNil())
+	_, err = client.Enqueue("q", "sub", nil, nil)
+	Ω(err).Should(BeNil())
+	Ω(client.Cancel("q", "sub")).Should(BeNil())
+	metrics.reset()
+
+	targets := []WaitTarget{
+		{Queue: "q", JobType: "add"},
+		{Queue: "q", JobType: "sub"},
+	}
+
+	{
+		results := client.WaitAll(targets, WaitOptions{
+			Timeout: 20 * time.Millisecond,
+		})
+		Ω(results[1].Err).Should(BeNil())
+
+		Ω(metrics.events).Should(Equal([]string{
+			"awaited q sub <nil>",
+			"awaited q add timeout",
+		}))
+		Ω(metrics.durations[1]).Should(
+			BeNumerically(">=", 20*time.Millisecond))
+		metrics.reset()
+	}
+
+	{
+		i, _ := client.WaitAny(targets)
+		Ω(i).Should(Equal(1))
+		Ω(metrics.events).Should(Equal([]string{"awaited q sub <nil>"}))
+		metrics.reset()
+	}
+
+	{
+		i, _ := client.WaitAny(targets[:1], WaitOptions{
+			Timeout: 20 * time.Millisecond,
+		})
+		Ω(i).Should(Equal(-1))
+		Ω(metrics.events).Should(Equal([]string{"awaited q add timeout"}))
+	}
+}
@@ -1,54 +1,223 @@
 package once
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math/rand"
+	"os"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/PlanitarInc/go-workers"
+	"github.com/bitly/go-simplejson"
 )
 
-type Middleware struct{}
+// The backoff of the jobs waiting for a slot is at most 2^6 seconds.
+const maxSlotBackoffShift = 6
+
+var defaultWorkerId = func() string {
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("%s:%d", hostname, os.Getpid())
+}()
+
+// Middleware tracks the state of the once-jobs while they are processed.
+// A zero value uses the default client, see Client.Middleware otherwise.
+//
+// A failed job asking for retries (see workers.MiddlewareRetry) is expected
+// to be retried by the retry middleware of go-workers, in any order
+// relative to this one. Its descriptor is kept for the longest delay the
+// retry middleware can pick, plus Options.RetryWaitTime.
+//
+// The On* hooks, if set, are called with the descriptor carried by the
+// message, as updated by the middleware: its status, update time and result
+// are set, the attempts are not. The hooks are called synchronously, after
+// the descriptor is updated in the store. The outcome of the job cancelled
+// or taken over by another job while running is not stored, so OnSuccess,
+// OnFailure and OnRetryScheduled are not called then.
+type Middleware struct {
+	// HeartbeatInterval is how often the descriptor of the executing job is
+	// kept from expiring while the job runs. Defaults to a third of
+	// Options.ExecWaitTime.
+	HeartbeatInterval time.Duration
+	// OnStart is called before the job is executed.
+	OnStart JobHook
+	// OnSuccess is called when the job has succeeded.
+	OnSuccess JobHook
+	// OnFailure is called when the job has failed for good: it panicked
+	// and is not retried, or it timed out (see Options.MaxRuntime), in
+	// which case the error is TimeoutErr.
+	OnFailure JobErrorHook
+	// OnRetryScheduled is called when the job has failed and is going to
+	// be retried by the retry middleware.
+	OnRetryScheduled JobErrorHook
+	// OnDroppedStale is called when the job of Options.AtMostOnce is
+	// dropped without executing, its descriptor being expired or taken
+	// over by another job.
+	OnDroppedStale JobHook
+	// OnLeaseLost is called when the descriptor of the executing job
+	// expired or was taken over by another job, so a duplicate might be
+	// running. It is called once, either from the heartbeat goroutine while
+	// the job is still running (and so is using the message), or when the
+	// final status of the job cannot be stored.
+	OnLeaseLost JobHook
+	// WorkerId identifies the process in the attempts of the jobs it runs,
+	// see JobDesc.Attempts. Defaults to "<hostname>:<pid>".
+	WorkerId string
+
+	client *Client
+}
+
+// JobHook is called by Middleware at a stage of the job's processing.
+type JobHook func(desc *JobDesc, message *workers.Msg)
+
+// JobErrorHook is called by Middleware when the job has failed, with the
+// error (or the value) it panicked with.
+type JobErrorHook func(desc *JobDesc, message *workers.Msg, err error)
 
 func (r *Middleware) Call(
 	queue string,
 	message *workers.Msg,
 	next func() bool,
 ) (acknowledge bool) {
-	conn := workers.Config.Pool.Get()
-	defer conn.Close()
+	client := r.client
+	if client == nil {
+		client = defaultClient()
+	}
 
-	jobDesc, ok := message.CheckGet("x-once")
+	key, ok := client.queueMsgKey(queue, message)
 	if !ok {
 		acknowledge = next()
 		return
 	}
 
 	jid := message.Jid()
+	jobDesc := message.Get("x-once")
 	jobType, _ := jobDesc.Get("job_type").String()
-	cleanQueuename := strings.TrimPrefix(queue, workers.Config.Namespace)
-	key := workers.Config.Namespace + "once:q:" + cleanQueuename + ":" + jobType
+	cleanQueuename := strings.TrimPrefix(queue, client.Namespace)
 	opts := optionsFromJson(jobDesc.Get("options"))
+	store := client.store()
+	worker := r.workerId()
+	metrics := client.metrics()
+	ctx := context.Background()
+
+	// The retry middleware updates the message if the job fails
+	retry := retryStateOf(message)
+
+	var hookDesc *JobDesc
+	if r.hasHooks() {
+		hookDesc = decodeJobDesc(jobDesc, message, cleanQueuename, opts)
+	}
+	// descWith returns a copy of the descriptor passed to the hooks, with
+	// the given status (unless empty) and result.
+	descWith := func(status, result string) *JobDesc {
+		desc := *hookDesc
+		if status != "" {
+			desc.Status = status
+			desc.UpdatedMs = time.Now().UnixNano() / 1e6
+		}
+		if result != "" {
+			desc.Result = result
+		}
+		return &desc
+	}
+
+	var startedAt time.Time
+	finished := func(outcome string) {
+		duration := time.Duration(0)
+		if !startedAt.IsZero() {
+			duration = time.Since(startedAt)
+		}
+		metrics.JobFinished(cleanQueuename, jobType, outcome, duration)
+	}
+	// leaseLost reports the executing job lost its descriptor, see
+	// OnLeaseLost.
+	leaseLost := func() {}
+	// complete stores the final status of the job and reports the outcome.
+	// Returns false if the descriptor was not updated, as the job was
+	// cancelled or is not the job of the descriptor anymore.
+	complete := func(status string, expire int, result string) bool {
+		n, _ := store.UpdateStatus(ctx, key, jid, status, expire, time.Now(),
+			result, worker)
+		switch n {
+		case -4:
+			// Keep the cancelled job as long as a failed one
+			store.UpdateStatus(ctx, key, jid, StatusCancelled,
+				opts.FailureRetention, time.Now(), "", worker)
+			finished(StatusCancelled)
+			return false
+		case -1, -2:
+			finished(OutcomeLeaseLost)
+			leaseLost()
+			return false
+		}
 
-	// XXX A hack to see whether a retry middleware is active and the job was
-	// rescheduled: if the retry counter increased, the job was rescheduled.
-	retryCount := r.getRetryCount(message)
+		finished(status)
+		return true
+	}
 
 	defer func() {
 		if e := recover(); e != nil {
-			newRetryCount := r.getRetryCount(message)
-			if retryCount < newRetryCount {
-				updateJobStatusWithResult(conn, key,
-					jid, StatusRetryWaiting, opts.RetryWaitTime, val2str(e))
+			if retry.willRetry() {
+				// Keep the descriptor until the retry is picked up
+				expire := int(retry.maxDelay()/time.Second) +
+					opts.RetryWaitTime
+				if complete(StatusRetryWaiting, expire, val2str(e)) &&
+					r.OnRetryScheduled != nil {
+					r.OnRetryScheduled(
+						descWith(StatusRetryWaiting, val2str(e)),
+						message, val2err(e))
+				}
 			} else {
-				updateJobStatusWithResult(conn, key,
-					jid, StatusFailed, opts.FailureRetention, val2str(e))
+				if complete(StatusFailed, opts.FailureRetention,
+					val2str(e)) && r.OnFailure != nil {
+					r.OnFailure(descWith(StatusFailed, val2str(e)), message,
+						val2err(e))
+				}
 			}
 
 			panic(e)
 		}
 	}()
 
-	n, _ := updateJobStatus(conn, key, jid, StatusExecuting, opts.ExecWaitTime)
+	semKey := ""
+	releaseSlot := func() {}
+	defer func() { releaseSlot() }()
+	if opts.MaxConcurrency > 0 {
+		semKey = client.semaphoreKey(cleanQueuename, jobType)
+		acquired, err := store.AcquireSlot(ctx, semKey, jid,
+			opts.MaxConcurrency, opts.ExecWaitTime)
+		if err != nil {
+			// Leave the job to be recovered rather than rescheduling it as
+			// if the slots were taken
+			acknowledge = false
+			return
+		}
+		if !acquired {
+			acknowledge = r.waitForSlot(ctx, client, key, message, opts)
+			finished(OutcomeRescheduled)
+			return
+		}
+		releaseSlot = func() { store.ReleaseSlot(ctx, semKey, jid) }
+	}
+
+	n, _ := store.UpdateStatus(ctx, key, jid, StatusExecuting,
+		opts.ExecWaitTime, time.Now(), "", worker)
+	if n == -3 {
+		// The job was postponed (debounced) while waiting to start, move
+		// it to the new start time instead of running.
+		acknowledge = r.reschedule(ctx, client, key, message)
+		finished(OutcomeRescheduled)
+		return
+	}
+	if n == -4 {
+		// The job was cancelled, skip it
+		acknowledge = true
+		finished(StatusCancelled)
+		return
+	}
 	if opts.AtMostOnce && n < 0 {
 		// Two reasons for getting here:
 		//  - (n=-1) the retention init/retry period of the job has elapsed,
@@ -58,21 +227,299 @@ func (r *Middleware) Call(
 		// In both cases, the job is kind of lost for the outer world, so we
 		// should silently drop it.
 		acknowledge = true
+		finished(OutcomeDropped)
+		if r.OnDroppedStale != nil {
+			r.OnDroppedStale(descWith("", ""), message)
+		}
 		return
 	}
 
-	acknowledge = next()
-	updateJobStatus(conn, key, jid, StatusOK, opts.SuccessRetention)
+	startedAt = time.Now()
+	if !retry.retried {
+		metrics.JobStarted(cleanQueuename, jobType,
+			startedAt.Sub(enqueuedAt(jobDesc, message)))
+	}
+	jobCtx, cancelJob, releaseJob := withJobContext(message, opts)
+	defer func() { releaseJob() }()
+
+	stopHeartbeat := func() {}
+	if n == 0 {
+		if r.OnLeaseLost != nil {
+			// Either the heartbeat or the final status update notices it
+			var reported sync.Once
+			desc := descWith(StatusExecuting, "")
+			leaseLost = func() {
+				reported.Do(func() { r.OnLeaseLost(desc, message) })
+			}
+		}
+		stopHeartbeat = r.startHeartbeat(ctx, store, jid, key, semKey, opts,
+			cancelJob, leaseLost)
+		defer stopHeartbeat()
+	}
+
+	// The result might be left by a failed attempt
+	message.Del(resultField)
+
+	if r.OnStart != nil {
+		r.OnStart(descWith(StatusExecuting, ""), message)
+	}
+
+	if opts.MaxRuntime > 0 {
+		var timedOut bool
+		abandonedJob, abandonedSlot := releaseJob, releaseSlot
+		acknowledge, timedOut = r.runWithDeadline(jobCtx, next, func() {
+			abandonedJob()
+			abandonedSlot()
+		})
+		if timedOut {
+			// The abandoned job keeps its context and its slot until it
+			// returns
+			releaseJob, releaseSlot = func() {}, func() {}
+			stopHeartbeat()
+			acknowledge = true
+			if complete(StatusTimedOut, opts.FailureRetention,
+				TimeoutErr.Error()) && r.OnFailure != nil {
+				r.OnFailure(descWith(StatusTimedOut, TimeoutErr.Error()),
+					message, TimeoutErr)
+			}
+			return
+		}
+	} else {
+		acknowledge = next()
+	}
+	stopHeartbeat()
+
+	result, _ := message.Get(resultField).String()
+	message.Del(resultField)
+
+	// Keep the descriptor as long as the job type is throttled
+	retention := opts.SuccessRetention
+	if opts.ThrottleWindow > retention {
+		retention = opts.ThrottleWindow
+	}
+	if complete(StatusOK, retention, result) && r.OnSuccess != nil {
+		r.OnSuccess(descWith(StatusOK, result), message)
+	}
 
 	return
 }
 
-func (r *Middleware) getRetryCount(message *workers.Msg) int {
-	if val, err := message.Get("retry_count").Int(); err != nil {
-		return -1
-	} else {
-		return val
+// decodeJobDesc returns the descriptor carried by the message.
+func decodeJobDesc(
+	jobDesc *simplejson.Json,
+	message *workers.Msg,
+	queue string,
+	opts *Options,
+) *JobDesc {
+	desc := &JobDesc{}
+	if data, err := jobDesc.MarshalJSON(); err == nil {
+		json.Unmarshal(data, desc)
+	}
+
+	desc.Jid = message.Jid()
+	desc.Queue = queue
+	desc.Options = opts
+
+	return desc
+}
+
+// enqueuedAt returns when the job was enqueued or, if later, scheduled to
+// run (the message of the rescheduled job carries the new time).
+func enqueuedAt(jobDesc *simplejson.Json, message *workers.Msg) time.Time {
+	ms, _ := jobDesc.Get("created_ms").Int64()
+	if at, err := message.Get("at").Float64(); err == nil &&
+		int64(at*1000) > ms {
+		ms = int64(at * 1000)
+	}
+
+	return time.Unix(0, ms*int64(time.Millisecond))
+}
+
+// runWithDeadline runs the job until it completes or the deadline of its
+// context passes, whichever happens first. The job running past the deadline
+// is abandoned: its outcome, including a panic, is ignored, and onReturn is
+// called once it eventually returns.
+func (r *Middleware) runWithDeadline(
+	ctx context.Context,
+	next func() bool,
+	onReturn func(),
+) (acknowledge bool, timedOut bool) {
+	type outcome struct {
+		acknowledge bool
+		panicked    bool
+		panicVal    interface{}
+	}
+	done := make(chan outcome, 1)
+	abandoned := make(chan bool, 1)
+
+	go func() {
+		o := outcome{}
+		defer func() {
+			if e := recover(); e != nil {
+				o.panicked, o.panicVal = true, e
+			}
+			done <- o
+			if <-abandoned {
+				onReturn()
+			}
+		}()
+		o.acknowledge = next()
+	}()
+
+	deadline, _ := ctx.Deadline()
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+
+	select {
+	case o := <-done:
+		abandoned <- false
+		if o.panicked {
+			// Let the usual failure handling take over
+			panic(o.panicVal)
+		}
+		return o.acknowledge, false
+
+	case <-timer.C:
+		abandoned <- true
+		return false, true
+	}
+}
+
+// startHeartbeat periodically extends the expiration time of the descriptor
+// of the executing job (and the lease of its concurrency slot, if any) until
+// the returned function is called. The function can be called many times.
+func (r *Middleware) startHeartbeat(
+	ctx context.Context,
+	store Store,
+	jid, key, semKey string,
+	opts *Options,
+	cancelJob func(),
+	leaseLost func(),
+) (stop func()) {
+	interval := r.HeartbeatInterval
+	if interval <= 0 {
+		interval = time.Duration(opts.ExecWaitTime) * time.Second / 3
+	}
+	if interval <= 0 {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	finished := make(chan struct{})
+
+	go func() {
+		defer close(finished)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+
+			if semKey != "" {
+				store.AcquireSlot(ctx, semKey, jid, opts.MaxConcurrency,
+					opts.ExecWaitTime)
+			}
+
+			n, err := store.Extend(ctx, key, jid, opts.ExecWaitTime)
+			if err != nil {
+				// Try again on the next tick
+				continue
+			}
+			if n == -3 {
+				// Not executing anymore, e.g. cancelled
+				cancelJob()
+				return
+			}
+			if n < 0 {
+				leaseLost()
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(done) })
+		<-finished
+	}
+}
+
+func (r *Middleware) reschedule(
+	ctx context.Context,
+	client *Client,
+	key string,
+	message *workers.Msg,
+) bool {
+	desc, err := client.store().Get(ctx, key)
+	if err != nil || desc.Jid != message.Jid() {
+		// The job is lost for the outer world, drop it
+		return true
+	}
+
+	return r.enqueueAt(ctx, client, message, desc.RunAt())
+}
+
+// waitForSlot reschedules the job that did not get a slot of the
+// concurrency limiting semaphore, backing off exponentially.
+func (r *Middleware) waitForSlot(
+	ctx context.Context,
+	client *Client,
+	key string,
+	message *workers.Msg,
+	opts *Options,
+) bool {
+	waits, _ := message.Get("x-once-slot-waits").Int()
+	message.Set("x-once-slot-waits", waits+1)
+
+	if waits > maxSlotBackoffShift {
+		waits = maxSlotBackoffShift
+	}
+	backoff := time.Duration(1<<uint(waits)) * time.Second
+	runAt := time.Now().Add(backoff +
+		time.Duration(rand.Int63n(int64(time.Second))))
+
+	// Keep the descriptor of the job while it is waiting
+	client.store().Postpone(ctx, key, message.Jid(), runAt,
+		debounceExpire(opts, runAt))
+
+	return r.enqueueAt(ctx, client, message, runAt)
+}
+
+func (r *Middleware) enqueueAt(
+	ctx context.Context,
+	client *Client,
+	message *workers.Msg,
+	at time.Time,
+) bool {
+	message.Set("at", float64(at.UnixNano())/1e9)
+	// If the job cannot be rescheduled, don't acknowledge it, otherwise it
+	// disappears into the void.
+	return client.enqueueMsg(ctx, message) == nil
+}
+
+func (r *Middleware) hasHooks() bool {
+	return r.OnStart != nil || r.OnSuccess != nil || r.OnFailure != nil ||
+		r.OnRetryScheduled != nil || r.OnDroppedStale != nil ||
+		r.OnLeaseLost != nil
+}
+
+func (r *Middleware) workerId() string {
+	if r.WorkerId != "" {
+		return r.WorkerId
+	}
+	return defaultWorkerId
+}
+
+func val2err(val interface{}) error {
+	if err, ok := val.(error); ok {
+		return err
 	}
+	return errors.New(val2str(val))
 }
 
 func val2str(val interface{}) string {
@@ -1,7 +1,10 @@
 package once
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
+	"strconv"
 	"testing"
 	"time"
 
@@ -155,7 +158,7 @@ func TestMiddlewareCall_Failed(t *testing.T) {
 
 	msg, _ := workers.NewMsg(`{
 		"jid": "3",
-		"retry": true,
+		"retry": false,
 		"x-once": {
 			"job_type": "rahamim"
 		}
@@ -251,9 +254,155 @@ func TestMiddlewareCall_Retrying(t *testing.T) {
 	}
 
 	{
+		// The first retry is at most 44 seconds away, plus RetryWaitTime
+		res, err := redis.Int(conn.Do("TTL", key))
+		Ω(err).Should(BeNil())
+		Ω(res).Should(Equal(44 + 60))
+	}
+}
+
+func TestMiddlewareCall_RetryThenSuccess(t *testing.T) {
+	RegisterTestingT(t)
+
+	setupRedis()
+	defer cleanRedis()
+
+	memClient, _, _ := newMetricsTestClient()
+	for _, client := range []*Client{defaultClient(), memClient} {
+		jid, err := client.Enqueue("tur-recovered", "yair", nil, nil)
+		Ω(err).Should(BeNil())
+
+		msg, _ := workers.NewMsg(`{
+			"jid": "` + jid + `",
+			"retry": true,
+			"x-once": {"job_type": "yair"}
+		}`)
+		m := client.Middleware()
+
+		Ω(func() {
+			_ = m.Call("tur-recovered", msg, func() bool {
+				rm := workers.MiddlewareRetry{}
+				return rm.Call("tur-recovered", msg, panicNext)
+			})
+		}).Should(Panic())
+
+		desc, err := client.GetDesc("tur-recovered", "yair")
+		Ω(err).Should(BeNil())
+		Ω(desc.Status).Should(Equal(StatusRetryWaiting))
+		Ω(desc.Result).Should(Equal("Allahu Akbar!"))
+
+		// Succeeds without a result
+		Ω(m.Call("tur-recovered", msg, func() bool {
+			return true
+		})).Should(BeTrue())
+
+		desc, err = client.GetDesc("tur-recovered", "yair")
+		Ω(err).Should(BeNil())
+		Ω(desc.Status).Should(Equal(StatusOK))
+		Ω(desc.Result).Should(BeEmpty())
+		Ω(desc.UnmarshalResult(&struct{}{})).Should(Equal(NoResultErr))
+		// The error is kept in the attempts
+		Ω(desc.Attempts).Should(HaveLen(2))
+		Ω(desc.Attempts[0].Error).Should(Equal("Allahu Akbar!"))
+	}
+}
+
+func TestMiddlewareCall_RetryingOuter(t *testing.T) {
+	RegisterTestingT(t)
+
+	setupRedis()
+	defer cleanRedis()
+
+	conn := workers.Config.Pool.Get()
+	defer conn.Close()
+
+	msg, _ := workers.NewMsg(`{
+		"jid": "16",
+		"retry": 5,
+		"retry_count": 2,
+		"x-once": {
+			"job_type": "yair"
+		}
+	}`)
+	queue := "tur-retrying-outer"
+	key := workers.Config.Namespace + "once:q:tur-retrying-outer:yair"
+
+	m := Middleware{}
+	rm := workers.MiddlewareRetry{}
+
+	{
+		res, err := redis.String(conn.Do("SET", key, `{"jid":"16"}`))
+		Ω(err).Should(BeNil())
+		Ω(res).Should(Equal("OK"))
+	}
+
+	{
+		// The retry middleware runs before this one
+		Ω(func() {
+			_ = rm.Call(queue, msg, func() bool {
+				return m.Call(queue, msg, panicNext)
+			})
+		}).Should(Panic())
+	}
+
+	{
+		desc, err := GetDesc("tur-retrying-outer", "yair")
+		Ω(err).Should(BeNil())
+		Ω(desc.Status).Should(Equal(StatusRetryWaiting))
+
+		// The third retry is at most 3^4+15+29*4 seconds away
 		res, err := redis.Int(conn.Do("TTL", key))
 		Ω(err).Should(BeNil())
-		Ω(res).Should(Equal(60))
+		Ω(res).Should(Equal(81 + 15 + 116 + 60))
+	}
+}
+
+func TestMiddlewareCall_RetriesExhausted(t *testing.T) {
+	RegisterTestingT(t)
+
+	setupRedis()
+	defer cleanRedis()
+
+	conn := workers.Config.Pool.Get()
+	defer conn.Close()
+
+	msg, _ := workers.NewMsg(`{
+		"jid": "17",
+		"max_attempts": 3,
+		"retry_count": 2,
+		"x-once": {
+			"job_type": "yair"
+		}
+	}`)
+	queue := "tur-exhausted"
+	key := workers.Config.Namespace + "once:q:tur-exhausted:yair"
+
+	m := Middleware{}
+
+	{
+		res, err := redis.String(conn.Do("SET", key, `{"jid":"17"}`))
+		Ω(err).Should(BeNil())
+		Ω(res).Should(Equal("OK"))
+	}
+
+	{
+		Ω(func() {
+			_ = m.Call(queue, msg, func() bool {
+				rm := workers.MiddlewareRetry{}
+				return rm.Call(queue, msg, panicNext)
+			})
+		}).Should(Panic())
+	}
+
+	{
+		// The retries are used up
+		desc, err := GetDesc("tur-exhausted", "yair")
+		Ω(err).Should(BeNil())
+		Ω(desc.Status).Should(Equal(StatusFailed))
+
+		res, err := redis.Int(conn.Do("TTL", key))
+		Ω(err).Should(BeNil())
+		Ω(res).Should(Equal(5))
 	}
 }
 
@@ -510,3 +659,798 @@ func getCountableCb() (*int, func() bool) {
 func panicNext() bool {
 	panic("Allahu Akbar!")
 }
+
+func TestMiddlewareCall_Postponed(t *testing.T) {
+	RegisterTestingT(t)
+
+	setupRedis()
+	defer cleanRedis()
+
+	conn := workers.Config.Pool.Get()
+	defer conn.Close()
+
+	msg, _ := workers.NewMsg(`{
+		"jid": "8",
+		"queue": "tur-postponed",
+		"retry": true,
+		"x-once": {
+			"job_type": "moti",
+			"options": {
+				"debounce": true
+			}
+		}
+	}`)
+	queue := "tur-postponed"
+	key := workers.Config.Namespace + "once:q:tur-postponed:moti"
+	schedule := workers.Config.Namespace + workers.SCHEDULED_JOBS_KEY
+	runAtMs := time2ms(time.Now().Add(time.Minute))
+	val := fmt.Sprintf(`{"jid":"8","status":"init-waiting","run_at_ms":%d}`,
+		runAtMs)
+
+	m := Middleware{}
+
+	{
+		res, err := redis.String(conn.Do("SET", key, val))
+		Ω(err).Should(BeNil())
+		Ω(res).Should(Equal("OK"))
+	}
+
+	{
+		counter, noopNext := getCountableCb()
+		ack := m.Call(queue, msg, noopNext)
+		Ω(ack).Should(BeTrue())
+		Ω(*counter).Should(Equal(0))
+	}
+
+	{
+		res, err := redis.String(conn.Do("GET", key))
+		Ω(err).Should(BeNil())
+		Ω(res).Should(Equal(val))
+	}
+
+	{
+		res, err := redis.Strings(conn.Do("zrange", schedule, 0, -1, "withscores"))
+		Ω(err).Should(BeNil())
+		Ω(res).Should(HaveLen(2))
+
+		rescheduled, err := workers.NewMsg(res[0])
+		Ω(err).Should(BeNil())
+		Ω(rescheduled.Jid()).Should(Equal("8"))
+
+		at, err := strconv.ParseFloat(res[1], 64)
+		Ω(err).Should(BeNil())
+		Ω(at).Should(BeNumerically("~", float64(runAtMs)/1000, 0.001))
+	}
+}
+
+func TestMiddlewareCall_MaxConcurrency(t *testing.T) {
+	RegisterTestingT(t)
+
+	setupRedis()
+	defer cleanRedis()
+
+	conn := workers.Config.Pool.Get()
+	defer conn.Close()
+
+	newMsg := func(jid string) *workers.Msg {
+		msg, _ := workers.NewMsg(`{
+			"jid": "` + jid + `",
+			"queue": "tur-limited",
+			"retry": true,
+			"x-once": {
+				"job_type": "moti",
+				"options": {
+					"unique_by_args": true,
+					"max_concurrency": 1
+				},
+				"unique_key": "` + jid + `"
+			}
+		}`)
+		return msg
+	}
+	queue := "tur-limited"
+	keyPrefix := workers.Config.Namespace + "once:q:tur-limited:moti:"
+	semKey := workers.Config.Namespace + "once:sem:tur-limited:moti"
+	schedule := workers.Config.Namespace + workers.SCHEDULED_JOBS_KEY
+	val := `{"jid":"%s","status":"init-waiting","unique_key":"%[1]s"}`
+
+	m := Middleware{}
+
+	for _, jid := range []string{"1", "2"} {
+		res, err := redis.String(conn.Do("SET", keyPrefix+jid,
+			fmt.Sprintf(val, jid)))
+		Ω(err).Should(BeNil())
+		Ω(res).Should(Equal("OK"))
+	}
+
+	{
+		// "1" holds the only slot while running, "2" has to wait
+		counter := 0
+		ack := m.Call(queue, newMsg("1"), func() bool {
+			counter++
+
+			innerCounter, noopNext := getCountableCb()
+			ack := m.Call(queue, newMsg("2"), noopNext)
+			Ω(ack).Should(BeTrue())
+			Ω(*innerCounter).Should(Equal(0))
+
+			return true
+		})
+		Ω(ack).Should(BeTrue())
+		Ω(counter).Should(Equal(1))
+	}
+
+	{
+		res, err := redis.String(conn.Do("GET", keyPrefix+"1"))
+		Ω(err).Should(BeNil())
+		Ω(res).Should(ContainSubstring(`"status":"ok"`))
+	}
+
+	{
+		res, err := redis.String(conn.Do("GET", keyPrefix+"2"))
+		Ω(err).Should(BeNil())
+		Ω(res).Should(ContainSubstring(`"status":"init-waiting"`))
+	}
+
+	{
+		res, err := redis.Strings(conn.Do("zrange", schedule, 0, -1, "withscores"))
+		Ω(err).Should(BeNil())
+		Ω(res).Should(HaveLen(2))
+
+		rescheduled, err := workers.NewMsg(res[0])
+		Ω(err).Should(BeNil())
+		Ω(rescheduled.Jid()).Should(Equal("2"))
+		Ω(rescheduled.Get("x-once-slot-waits").MustInt()).Should(Equal(1))
+
+		at, err := strconv.ParseFloat(res[1], 64)
+		Ω(err).Should(BeNil())
+		now := float64(time.Now().UnixNano()) / 1e9
+		Ω(at).Should(BeNumerically(">", now))
+		Ω(at).Should(BeNumerically("<", now+2))
+	}
+
+	{
+		// The slot is released after the run
+		res, err := redis.Int(conn.Do("ZCARD", semKey))
+		Ω(err).Should(BeNil())
+		Ω(res).Should(Equal(0))
+	}
+
+	{
+		counter, noopNext := getCountableCb()
+		msg := newMsg("2")
+		msg.Set("x-once-slot-waits", 1)
+		ack := m.Call(queue, msg, noopNext)
+		Ω(ack).Should(BeTrue())
+		// Cannot start before the backoff passes
+		Ω(*counter).Should(Equal(0))
+	}
+}
+
+// slotErrStore fails to acquire the slots of the semaphores.
+type slotErrStore struct {
+	Store
+}
+
+func (s slotErrStore) AcquireSlot(
+	ctx context.Context,
+	key, jid string,
+	limit, lease int,
+) (bool, error) {
+	return false, fmt.Errorf("connection refused")
+}
+
+func TestMiddlewareCall_MaxConcurrencyError(t *testing.T) {
+	RegisterTestingT(t)
+
+	enqueued := 0
+	client := &Client{
+		Store: slotErrStore{NewMemoryStore()},
+		EnqueueMsg: func(ctx context.Context, msg *workers.Msg) error {
+			enqueued++
+			return nil
+		},
+	}
+
+	_, err := client.Enqueue("q", "limited", nil,
+		&Options{MaxConcurrency: 1})
+	Ω(err).Should(BeNil())
+	Ω(enqueued).Should(Equal(1))
+
+	desc, err := client.GetDesc("q", "limited")
+	Ω(err).Should(BeNil())
+	descJson, err := json.Marshal(desc)
+	Ω(err).Should(BeNil())
+	msg, err := workers.NewMsg(`{"jid":"` + desc.Jid + `","x-once":` +
+		string(descJson) + `}`)
+	Ω(err).Should(BeNil())
+
+	{
+		// Neither run nor rescheduled, nor acknowledged
+		counter, noopNext := getCountableCb()
+		ack := client.Middleware().Call("q", msg, noopNext)
+		Ω(ack).Should(BeFalse())
+		Ω(*counter).Should(Equal(0))
+		Ω(enqueued).Should(Equal(1))
+	}
+
+	{
+		desc, err := client.GetDesc("q", "limited")
+		Ω(err).Should(BeNil())
+		Ω(desc.Status).Should(Equal(StatusInitWaiting))
+	}
+}
+
+func TestMiddlewareCall_Heartbeat(t *testing.T) {
+	RegisterTestingT(t)
+
+	setupRedis()
+	defer cleanRedis()
+
+	conn := workers.Config.Pool.Get()
+	defer conn.Close()
+
+	msg, _ := workers.NewMsg(`{
+		"jid": "9",
+		"queue": "tur-long",
+		"x-once": {
+			"job_type": "moti",
+			"options": {
+				"exec_wait": 1
+			}
+		}
+	}`)
+	queue := "tur-long"
+	key := workers.Config.Namespace + "once:q:tur-long:moti"
+
+	lost := 0
+	m := Middleware{
+		HeartbeatInterval: 100 * time.Millisecond,
+		OnLeaseLost: func(desc *JobDesc, message *workers.Msg) {
+			lost++
+		},
+	}
+
+	{
+		res, err := redis.String(conn.Do("SET", key,
+			`{"jid":"9","status":"init-waiting"}`))
+		Ω(err).Should(BeNil())
+		Ω(res).Should(Equal("OK"))
+	}
+
+	{
+		ack := m.Call(queue, msg, func() bool {
+			// Runs longer than the descriptor lives without the heartbeat
+			time.Sleep(1500 * time.Millisecond)
+
+			res, err := redis.String(conn.Do("GET", key))
+			Ω(err).Should(BeNil())
+			Ω(res).Should(ContainSubstring(`"status":"executing"`))
+
+			return true
+		})
+		Ω(ack).Should(BeTrue())
+		Ω(lost).Should(Equal(0))
+	}
+
+	{
+		res, err := redis.String(conn.Do("GET", key))
+		Ω(err).Should(BeNil())
+		Ω(res).Should(ContainSubstring(`"status":"ok"`))
+	}
+}
+
+func TestMiddlewareCall_LeaseLost(t *testing.T) {
+	RegisterTestingT(t)
+
+	setupRedis()
+	defer cleanRedis()
+
+	conn := workers.Config.Pool.Get()
+	defer conn.Close()
+
+	msg, _ := workers.NewMsg(`{
+		"jid": "10",
+		"queue": "tur-lost",
+		"x-once": {
+			"job_type": "moti"
+		}
+	}`)
+	queue := "tur-lost"
+	key := workers.Config.Namespace + "once:q:tur-lost:moti"
+
+	type lease struct{ queue, jobType, jid string }
+	lost := []lease{}
+	m := Middleware{
+		HeartbeatInterval: 50 * time.Millisecond,
+		OnLeaseLost: func(desc *JobDesc, message *workers.Msg) {
+			lost = append(lost, lease{desc.Queue, desc.JobType, desc.Jid})
+		},
+	}
+
+	{
+		res, err := redis.String(conn.Do("SET", key,
+			`{"jid":"10","status":"init-waiting"}`))
+		Ω(err).Should(BeNil())
+		Ω(res).Should(Equal("OK"))
+	}
+
+	{
+		ack := m.Call(queue, msg, func() bool {
+			// Another job takes over
+			_, err := conn.Do("SET", key, `{"jid":"11","status":"executing"}`)
+			Ω(err).Should(BeNil())
+
+			time.Sleep(200 * time.Millisecond)
+			return true
+		})
+		Ω(ack).Should(BeTrue())
+		Ω(lost).Should(Equal([]lease{{"tur-lost", "moti", "10"}}))
+	}
+
+	{
+		res, err := redis.String(conn.Do("GET", key))
+		Ω(err).Should(BeNil())
+		Ω(res).Should(Equal(`{"jid":"11","status":"executing"}`))
+	}
+}
+
+func TestMiddlewareCall_LeaseLostOnCompletion(t *testing.T) {
+	RegisterTestingT(t)
+
+	client, _, msgs := newMetricsTestClient()
+
+	lost := []string{}
+	m := client.Middleware()
+	// No heartbeat during the run
+	m.HeartbeatInterval = time.Hour
+	m.OnLeaseLost = func(desc *JobDesc, message *workers.Msg) {
+		lost = append(lost, desc.Jid)
+	}
+
+	jid, err := client.Enqueue("q", "force", nil, nil)
+	Ω(err).Should(BeNil())
+	msg := <-msgs
+
+	ack := m.Call("q", msg, func() bool {
+		_, err := client.EnqueueForce("q", "force", nil, nil)
+		Ω(err).Should(BeNil())
+		<-msgs
+		return true
+	})
+	Ω(ack).Should(BeTrue())
+	Ω(lost).Should(Equal([]string{jid}))
+}
+
+func TestMiddlewareCall_Result(t *testing.T) {
+	RegisterTestingT(t)
+
+	setupRedis()
+	defer cleanRedis()
+
+	conn := workers.Config.Pool.Get()
+	defer conn.Close()
+
+	msg, _ := workers.NewMsg(`{
+		"jid": "12",
+		"queue": "tur-result",
+		"x-once": {
+			"job_type": "moti"
+		},
+		"x-once-result": "\"stale\""
+	}`)
+	queue := "tur-result"
+
+	m := Middleware{}
+
+	{
+		res, err := redis.String(conn.Do("SET",
+			workers.Config.Namespace+"once:q:tur-result:moti",
+			`{"jid":"12","status":"init-waiting"}`))
+		Ω(err).Should(BeNil())
+		Ω(res).Should(Equal("OK"))
+	}
+
+	{
+		ack := m.Call(queue, msg, func() bool {
+			// A result left by a previous attempt is not visible
+			_, ok := msg.CheckGet("x-once-result")
+			Ω(ok).Should(BeFalse())
+
+			Ω(SetResult(msg, []int{1, 2, 3})).Should(BeNil())
+			return true
+		})
+		Ω(ack).Should(BeTrue())
+	}
+
+	{
+		desc, err := GetDesc("tur-result", "moti")
+		Ω(err).Should(BeNil())
+		Ω(desc.Status).Should(Equal(StatusOK))
+
+		var res []int
+		Ω(desc.UnmarshalResult(&res)).Should(BeNil())
+		Ω(res).Should(Equal([]int{1, 2, 3}))
+	}
+}
+
+func TestMiddlewareCall_MaxRuntime(t *testing.T) {
+	RegisterTestingT(t)
+
+	setupRedis()
+	defer cleanRedis()
+
+	conn := workers.Config.Pool.Get()
+	defer conn.Close()
+
+	msg, _ := workers.NewMsg(`{
+		"jid": "13",
+		"queue": "tur-hung",
+		"x-once": {
+			"job_type": "moti",
+			"options": {
+				"max_runtime": 1
+			}
+		}
+	}`)
+	queue := "tur-hung"
+
+	m := Middleware{}
+
+	{
+		res, err := redis.String(conn.Do("SET",
+			workers.Config.Namespace+"once:q:tur-hung:moti",
+			`{"jid":"13","status":"init-waiting"}`))
+		Ω(err).Should(BeNil())
+		Ω(res).Should(Equal("OK"))
+	}
+
+	stopped := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+
+	{
+		ack := m.Call(queue, msg, func() bool {
+			// A cooperative handler is told to stop
+			<-JobContext(msg).Done()
+			Ω(JobContext(msg).Err()).Should(Equal(context.DeadlineExceeded))
+			close(stopped)
+
+			// But the job is abandoned even if it doesn't
+			<-release
+			return true
+		})
+		Ω(ack).Should(BeTrue())
+		Eventually(stopped).Should(BeClosed())
+
+		// The abandoned job still finds its context
+		Ω(JobContext(msg).Err()).Should(Equal(context.DeadlineExceeded))
+	}
+
+	{
+		desc, err := GetDesc("tur-hung", "moti")
+		Ω(err).Should(BeNil())
+		Ω(desc.Status).Should(Equal(StatusTimedOut))
+		Ω(desc.Result).Should(Equal(TimeoutErr.Error()))
+		Ω(desc.IsFailed()).Should(BeTrue())
+		Ω(desc.IsDone()).Should(BeTrue())
+
+		ttl, err := redis.Int(conn.Do("TTL",
+			workers.Config.Namespace+"once:q:tur-hung:moti"))
+		Ω(err).Should(BeNil())
+		Ω(ttl).Should(BeNumerically("~", 5, 1))
+	}
+}
+
+func TestMiddlewareCall_MaxRuntimeSlot(t *testing.T) {
+	RegisterTestingT(t)
+
+	client, _, msgs := newMetricsTestClient()
+	m := client.Middleware()
+	store := client.store()
+	semKey := client.semaphoreKey("q", "hung")
+	ctx := context.Background()
+
+	_, err := client.Enqueue("q", "hung", nil, &Options{
+		MaxRuntime:     1,
+		MaxConcurrency: 1,
+	})
+	Ω(err).Should(BeNil())
+	msg := <-msgs
+
+	release := make(chan struct{})
+	returned := make(chan struct{})
+	ack := m.Call("q", msg, func() bool {
+		defer close(returned)
+		<-release
+		return true
+	})
+	Ω(ack).Should(BeTrue())
+
+	// The abandoned job keeps its slot
+	acquired, err := store.AcquireSlot(ctx, semKey, "other", 1, 60)
+	Ω(err).Should(BeNil())
+	Ω(acquired).Should(BeFalse())
+
+	close(release)
+	<-returned
+	Eventually(func() error {
+		return JobContext(msg).Err()
+	}).Should(BeNil())
+	Eventually(func() bool {
+		acquired, _ := store.AcquireSlot(ctx, semKey, "other", 1, 60)
+		return acquired
+	}).Should(BeTrue())
+}
+
+func TestMiddlewareCall_MaxRuntimeNotExceeded(t *testing.T) {
+	RegisterTestingT(t)
+
+	setupRedis()
+	defer cleanRedis()
+
+	conn := workers.Config.Pool.Get()
+	defer conn.Close()
+
+	msg, _ := workers.NewMsg(`{
+		"jid": "14",
+		"queue": "tur-quick",
+		"x-once": {
+			"job_type": "moti",
+			"options": {
+				"max_runtime": 10
+			}
+		}
+	}`)
+	queue := "tur-quick"
+
+	m := Middleware{}
+
+	{
+		res, err := redis.String(conn.Do("SET",
+			workers.Config.Namespace+"once:q:tur-quick:moti",
+			`{"jid":"14","status":"init-waiting"}`))
+		Ω(err).Should(BeNil())
+		Ω(res).Should(Equal("OK"))
+	}
+
+	{
+		ack := m.Call(queue, msg, func() bool {
+			Ω(JobContext(msg).Err()).Should(BeNil())
+			return false
+		})
+		Ω(ack).Should(BeFalse())
+
+		// The context is gone with the job
+		Ω(JobContext(msg)).Should(Equal(context.Background()))
+
+		desc, err := GetDesc("tur-quick", "moti")
+		Ω(err).Should(BeNil())
+		Ω(desc.Status).Should(Equal(StatusOK))
+	}
+
+	{
+		// A panic is handled as usual
+		Ω(func() {
+			m.Call(queue, msg, func() bool {
+				panic("boom")
+			})
+		}).Should(PanicWith("boom"))
+
+		desc, err := GetDesc("tur-quick", "moti")
+		Ω(err).Should(BeNil())
+		Ω(desc.Status).Should(Equal(StatusFailed))
+		Ω(desc.Result).Should(Equal("boom"))
+	}
+}
+
+func TestMiddlewareCall_Attempts(t *testing.T) {
+	RegisterTestingT(t)
+
+	setupRedis()
+	defer cleanRedis()
+
+	conn := workers.Config.Pool.Get()
+	defer conn.Close()
+
+	msg, _ := workers.NewMsg(`{
+		"jid": "15",
+		"retry": true,
+		"x-once": {
+			"job_type": "yair"
+		}
+	}`)
+	queue := "tur-attempts"
+
+	m := Middleware{WorkerId: "worker-1"}
+
+	{
+		res, err := redis.String(conn.Do("SET",
+			workers.Config.Namespace+"once:q:tur-attempts:yair",
+			`{"jid":"15","status":"init-waiting"}`))
+		Ω(err).Should(BeNil())
+		Ω(res).Should(Equal("OK"))
+	}
+
+	{
+		Ω(func() {
+			_ = m.Call(queue, msg, func() bool {
+				rm := workers.MiddlewareRetry{}
+				return rm.Call(queue, msg, panicNext)
+			})
+		}).Should(Panic())
+	}
+
+	{
+		m.WorkerId = "worker-2"
+		counter, cb := getCountableCb()
+		ack := m.Call(queue, msg, cb)
+		Ω(ack).Should(BeTrue())
+		Ω(*counter).Should(Equal(1))
+	}
+
+	{
+		desc, err := GetDesc("tur-attempts", "yair")
+		Ω(err).Should(BeNil())
+		Ω(desc.Status).Should(Equal(StatusOK))
+
+		Ω(desc.Attempts).Should(HaveLen(2))
+		Ω(desc.Attempts[0].Outcome).Should(Equal(StatusRetryWaiting))
+		Ω(desc.Attempts[0].Error).ShouldNot(BeEmpty())
+		Ω(desc.Attempts[0].Worker).Should(Equal("worker-1"))
+		Ω(desc.Attempts[1].Outcome).Should(Equal(StatusOK))
+		Ω(desc.Attempts[1].Error).Should(BeEmpty())
+		Ω(desc.Attempts[1].Worker).Should(Equal("worker-2"))
+		Ω(desc.Attempts[1].StartMs).Should(
+			BeNumerically(">=", desc.Attempts[0].EndMs))
+	}
+}
+
+func TestMiddlewareCall_Hooks(t *testing.T) {
+	RegisterTestingT(t)
+
+	client, _, msgs := newMetricsTestClient()
+
+	calls := []string{}
+	hook := func(name string) JobHook {
+		return func(desc *JobDesc, message *workers.Msg) {
+			Ω(desc.Jid).Should(Equal(message.Jid()))
+			calls = append(calls, fmt.Sprintf("%s %s/%s %s %s", name,
+				desc.Queue, desc.JobType, desc.Status, desc.Result))
+		}
+	}
+	errorHook := func(name string) JobErrorHook {
+		return func(desc *JobDesc, message *workers.Msg, err error) {
+			calls = append(calls, fmt.Sprintf("%s %s/%s %s %v", name,
+				desc.Queue, desc.JobType, desc.Status, err))
+		}
+	}
+
+	m := client.Middleware()
+	m.OnStart = hook("start")
+	m.OnSuccess = hook("success")
+	m.OnFailure = errorHook("failure")
+	m.OnRetryScheduled = errorHook("retry")
+	m.OnDroppedStale = hook("dropped")
+
+	{
+		_, err := client.Enqueue("q", "add", nil, nil)
+		Ω(err).Should(BeNil())
+		msg := <-msgs
+
+		ack := m.Call("q", msg, func() bool {
+			Ω(SetResult(msg, 3)).Should(BeNil())
+			return true
+		})
+		Ω(ack).Should(BeTrue())
+	}
+
+	{
+		_, err := client.Enqueue("q", "retry", nil, nil)
+		Ω(err).Should(BeNil())
+		msg := <-msgs
+		msg.Set("retry", true)
+
+		Ω(func() { m.Call("q", msg, panicNext) }).Should(Panic())
+	}
+
+	{
+		_, err := client.Enqueue("q", "fail", nil, nil)
+		Ω(err).Should(BeNil())
+		msg := <-msgs
+		msg.Set("retry", false)
+
+		Ω(func() { m.Call("q", msg, panicNext) }).Should(Panic())
+	}
+
+	{
+		_, err := client.Enqueue("q", "slow", nil, &Options{MaxRuntime: 1})
+		Ω(err).Should(BeNil())
+		msg := <-msgs
+
+		ack := m.Call("q", msg, func() bool {
+			time.Sleep(1500 * time.Millisecond)
+			return true
+		})
+		Ω(ack).Should(BeTrue())
+	}
+
+	{
+		_, err := client.Enqueue("q", "drop", nil, &Options{AtMostOnce: true})
+		Ω(err).Should(BeNil())
+		msg := <-msgs
+		_, err = client.EnqueueForce("q", "drop", nil, nil)
+		Ω(err).Should(BeNil())
+		<-msgs
+
+		counter, next := getCountableCb()
+		Ω(m.Call("q", msg, next)).Should(BeTrue())
+		Ω(*counter).Should(Equal(0))
+	}
+
+	Ω(calls).Should(Equal([]string{
+		"start q/add executing ",
+		"success q/add ok 3",
+		"start q/retry executing ",
+		"retry q/retry retry-waiting Allahu Akbar!",
+		"start q/fail executing ",
+		"failure q/fail failed Allahu Akbar!",
+		"start q/slow executing ",
+		"failure q/slow timed-out timeout",
+		"dropped q/drop init-waiting ",
+	}))
+}
+
+func TestMiddlewareCall_HooksNotStored(t *testing.T) {
+	RegisterTestingT(t)
+
+	client, metrics, msgs := newMetricsTestClient()
+
+	succeeded := 0
+	m := client.Middleware()
+	m.OnSuccess = func(desc *JobDesc, message *workers.Msg) {
+		succeeded++
+	}
+
+	{
+		// Cancelled while running
+		_, err := client.Enqueue("q", "cancel", nil, nil)
+		Ω(err).Should(BeNil())
+		msg := <-msgs
+		metrics.reset()
+
+		ack := m.Call("q", msg, func() bool {
+			Ω(client.Cancel("q", "cancel")).Should(BeNil())
+			return true
+		})
+		Ω(ack).Should(BeTrue())
+		Ω(succeeded).Should(Equal(0))
+		Ω(metrics.events).Should(ContainElement("finished q cancel cancelled"))
+
+		desc, err := client.GetDesc("q", "cancel")
+		Ω(err).Should(BeNil())
+		Ω(desc.Status).Should(Equal(StatusCancelled))
+	}
+
+	{
+		// Replaced while running
+		_, err := client.Enqueue("q", "force", nil, nil)
+		Ω(err).Should(BeNil())
+		msg := <-msgs
+		metrics.reset()
+
+		ack := m.Call("q", msg, func() bool {
+			_, err := client.EnqueueForce("q", "force", nil, nil)
+			Ω(err).Should(BeNil())
+			<-msgs
+			return true
+		})
+		Ω(ack).Should(BeTrue())
+		Ω(succeeded).Should(Equal(0))
+		Ω(metrics.events).Should(ContainElement("finished q force lease-lost"))
+
+		desc, err := client.GetDesc("q", "force")
+		Ω(err).Should(BeNil())
+		Ω(desc.Status).Should(Equal(StatusInitWaiting))
+	}
+}
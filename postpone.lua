@@ -0,0 +1,37 @@
+-- KEYS:
+--  [1] key of the job descriptor
+-- ARGUMENTS:
+--  [1] Expected JID
+--  [2] New start timestamp (in ms) of the job
+--  [3] New expiration time for the job descriptor
+--
+--  Return values:
+--    0  in case of success
+--   -1  if the key does not exist
+--   -2  if the JID is wrong
+--   -3  if the job is not waiting to start anymore
+
+local val = redis.call("GET", KEYS[1])
+
+if val == false then
+  return -1
+end
+
+val = cjson.decode(val)
+if val["jid"] ~= ARGV[1] then
+  return -2
+end
+
+if val["status"] ~= "init-waiting" then
+  return -3
+end
+
+-- Never move the start time backwards
+local runAtMs = tonumber(ARGV[2])
+if tonumber(val["run_at_ms"] or 0) < runAtMs then
+  val["run_at_ms"] = runAtMs
+end
+
+redis.call("SET", KEYS[1], cjson.encode(val))
+redis.call("EXPIRE", KEYS[1], ARGV[3])
+return 0
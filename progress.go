@@ -0,0 +1,59 @@
+package once
+
+import (
+	"context"
+	"errors"
+
+	"github.com/PlanitarInc/go-workers"
+)
+
+var (
+	NotOnceJobErr   = errors.New("not a once-job")
+	NotExecutingErr = errors.New("job is not executing")
+)
+
+// SetProgress reports the progress of the job being processed, e.g. 42 and
+// "transcoding". It is meant to be called by the job handler: the progress
+// is saved into the job descriptor and published to the watchers (see
+// Watch).
+//
+// Returns ExpiredErr or SupersededErr if the job does not own its
+// descriptor anymore.
+func SetProgress(
+	message *workers.Msg,
+	progress float64,
+	progressMessage string,
+) error {
+	return defaultClient().SetProgress(context.Background(), message,
+		progress, progressMessage)
+}
+
+// SetProgress is the client's counterpart of the package-level SetProgress.
+func (c *Client) SetProgress(
+	ctx context.Context,
+	message *workers.Msg,
+	progress float64,
+	progressMessage string,
+) error {
+	key, ok := c.msgKey(message)
+	if !ok {
+		return NotOnceJobErr
+	}
+
+	n, err := c.store().SetProgress(ctx, key, message.Jid(), progress,
+		progressMessage)
+	if err != nil {
+		return err
+	}
+
+	switch n {
+	case -1:
+		return ExpiredErr
+	case -2:
+		return SupersededErr
+	case -3:
+		return NotExecutingErr
+	}
+
+	return nil
+}
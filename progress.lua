@@ -0,0 +1,46 @@
+-- KEYS:
+--  [1] key of the job descriptor
+-- ARGUMENTS:
+--  [1] Expected JID
+--  [2] Progress of the job
+--  [3] Progress message
+--
+--  Return values:
+--    0  in case of success
+--   -1  if the key does not exist
+--   -2  if the JID is wrong
+--   -3  if the job is not executing
+
+local val = redis.call("GET", KEYS[1])
+
+if val == false then
+  return -1
+end
+
+val = cjson.decode(val)
+if val["jid"] ~= ARGV[1] then
+  return -2
+end
+
+if val["status"] ~= "executing" then
+  return -3
+end
+
+val["progress"] = tonumber(ARGV[2])
+if ARGV[3] ~= "" then
+  val["progress_message"] = ARGV[3]
+else
+  val["progress_message"] = nil
+end
+
+-- Keep the expiration time
+local ttl = redis.call("PTTL", KEYS[1])
+if ttl > 0 then
+  redis.call("SET", KEYS[1], cjson.encode(val), "PX", ttl)
+else
+  redis.call("SET", KEYS[1], cjson.encode(val))
+end
+
+val["event"] = "progress"
+redis.call("PUBLISH", KEYS[1], cjson.encode(val))
+return 0
@@ -0,0 +1,89 @@
+package once
+
+import (
+	"context"
+	"testing"
+
+	"github.com/PlanitarInc/go-workers"
+	. "github.com/onsi/gomega"
+)
+
+func TestSetProgress(t *testing.T) {
+	RegisterTestingT(t)
+
+	setupRedis()
+	defer cleanRedis()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	queue := "progress-1"
+	jobType := "transcode"
+
+	jid, err := Enqueue(queue, jobType, nil, nil)
+	Ω(err).Should(BeNil())
+
+	descs, err := Watch(ctx, queue, jobType)
+	Ω(err).Should(BeNil())
+
+	msg, _ := workers.NewMsg(`{
+		"jid": "` + jid + `",
+		"queue": "progress-1",
+		"x-once": {"job_type": "transcode"}
+	}`)
+
+	{
+		// Not executing yet
+		err := SetProgress(msg, 1, "")
+		Ω(err).Should(Equal(NotExecutingErr))
+	}
+
+	m := Middleware{}
+	ack := m.Call(queue, msg, func() bool {
+		err := SetProgress(msg, 42, "transcoding")
+		Ω(err).Should(BeNil())
+		return true
+	})
+	Ω(ack).Should(BeTrue())
+
+	{
+		var n Notification
+		Eventually(descs).Should(Receive(&n))
+		desc := n.Desc
+		Ω(desc.Status).Should(Equal(StatusExecuting))
+		Ω(n.Event).Should(Equal(EventStatus))
+	}
+
+	{
+		var n Notification
+		Eventually(descs).Should(Receive(&n))
+		desc := n.Desc
+		Ω(desc.Status).Should(Equal(StatusExecuting))
+		Ω(n.Event).Should(Equal(EventProgress))
+		Ω(desc.Progress).Should(Equal(42.0))
+		Ω(desc.ProgressMessage).Should(Equal("transcoding"))
+	}
+
+	{
+		var n Notification
+		Eventually(descs).Should(Receive(&n))
+		desc := n.Desc
+		Ω(desc.Status).Should(Equal(StatusOK))
+		Ω(n.Event).Should(Equal(EventStatus))
+		Ω(desc.Progress).Should(Equal(42.0))
+	}
+
+	{
+		notOnce, _ := workers.NewMsg(`{"jid":"1","queue":"progress-1"}`)
+		err := SetProgress(notOnce, 1, "")
+		Ω(err).Should(Equal(NotOnceJobErr))
+	}
+
+	{
+		_, err := EnqueueForce(queue, jobType, nil, nil)
+		Ω(err).Should(BeNil())
+
+		err = SetProgress(msg, 1, "")
+		Ω(err).Should(Equal(SupersededErr))
+	}
+}
@@ -0,0 +1,37 @@
+package once
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/PlanitarInc/go-workers"
+)
+
+var NoResultErr = errors.New("no result")
+
+// The message field carrying the result set by the job.
+const resultField = "x-once-result"
+
+// SetResult stores the result of the job being processed in its message.
+// Once the job succeeds, Middleware saves the JSON-encoded value into the
+// job descriptor, see JobDesc.UnmarshalResult.
+func SetResult(message *workers.Msg, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	message.Set(resultField, string(data))
+	return nil
+}
+
+// UnmarshalResult decodes the result set by the succeeded job (see
+// SetResult) into v. Returns NoResultErr if the job has not succeeded or
+// did not set a result.
+func (d JobDesc) UnmarshalResult(v interface{}) error {
+	if !d.IsOK() || d.Result == "" {
+		return NoResultErr
+	}
+
+	return json.Unmarshal([]byte(d.Result), v)
+}
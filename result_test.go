@@ -0,0 +1,56 @@
+package once
+
+import (
+	"testing"
+
+	"github.com/PlanitarInc/go-workers"
+	. "github.com/onsi/gomega"
+)
+
+func TestSetResult(t *testing.T) {
+	RegisterTestingT(t)
+
+	type result struct {
+		Sum   int    `json:"sum"`
+		Label string `json:"label"`
+	}
+
+	msg, _ := workers.NewMsg(`{"jid":"1"}`)
+
+	{
+		err := SetResult(msg, result{Sum: 3, Label: "1+2"})
+		Ω(err).Should(BeNil())
+		Ω(msg.Get(resultField).MustString()).Should(
+			MatchJSON(`{"sum":3,"label":"1+2"}`))
+	}
+
+	{
+		err := SetResult(msg, make(chan int))
+		Ω(err).ShouldNot(BeNil())
+	}
+
+	{
+		desc := JobDesc{
+			Status: StatusOK,
+			Result: msg.Get(resultField).MustString(),
+		}
+
+		var res result
+		err := desc.UnmarshalResult(&res)
+		Ω(err).Should(BeNil())
+		Ω(res).Should(Equal(result{Sum: 3, Label: "1+2"}))
+	}
+
+	{
+		var res result
+		err := JobDesc{Status: StatusOK}.UnmarshalResult(&res)
+		Ω(err).Should(Equal(NoResultErr))
+	}
+
+	{
+		// The result of a failed job is its error
+		var res result
+		err := JobDesc{Status: StatusFailed, Result: "boom"}.UnmarshalResult(&res)
+		Ω(err).Should(Equal(NoResultErr))
+	}
+}
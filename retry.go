@@ -0,0 +1,66 @@
+package once
+
+import (
+	"math"
+	"time"
+
+	"github.com/PlanitarInc/go-workers"
+)
+
+// retryState is the retry metadata of a message before the job runs. The
+// retry middleware of go-workers updates the message when the job fails, so
+// the state has to be captured before that, whatever the order of the
+// middlewares is.
+type retryState struct {
+	// retry is set if the message asks for retries.
+	retry bool
+	// maxRetries is the number of the retries allowed.
+	maxRetries int
+	// count is the retry_count of the message, 0 if not retried yet.
+	count int
+	// retried is set if the message was retried already.
+	retried bool
+}
+
+// retryStateOf reads the retry metadata the same way
+// workers.MiddlewareRetry does.
+func retryStateOf(message *workers.Msg) retryState {
+	s := retryState{maxRetries: workers.DEFAULT_MAX_RETRY}
+
+	if param, err := message.Get("retry").Bool(); err == nil {
+		s.retry = param
+	} else if param, err := message.Get("retry").Int(); err == nil {
+		s.maxRetries = param
+		s.retry = true
+	}
+	if param, err := message.Get("max_attempts").Int(); err == nil {
+		s.maxRetries = param - 1
+		s.retry = true
+	}
+
+	if count, err := message.Get("retry_count").Int(); err == nil {
+		s.count = count
+		s.retried = true
+	}
+
+	return s
+}
+
+// willRetry returns true if the retry middleware reschedules the job
+// failing now.
+func (s retryState) willRetry() bool {
+	return s.retry && s.count < s.maxRetries
+}
+
+// maxDelay returns the longest delay the retry middleware can pick for
+// the next retry of the job.
+func (s retryState) maxDelay() time.Duration {
+	next := 0
+	if s.retried {
+		next = s.count + 1
+	}
+
+	// See the randomized secondsToDelay() of go-workers
+	seconds := int(math.Pow(float64(next), 4)) + 15 + 29*(next+1)
+	return time.Duration(seconds) * time.Second
+}
@@ -2,6 +2,7 @@ package once
 
 import (
 	_ "embed"
+	"encoding/json"
 	"time"
 
 	"github.com/gomodule/redigo/redis"
@@ -11,6 +12,13 @@ var (
 	// NOTE: redigo takes care of loading the script for the first time,
 	// so we don't have to 'SCRIPT LOAD' it manually.
 	updateStateScript *redis.Script
+	setJobDescScript  *redis.Script
+	postponeJobScript *redis.Script
+	semaphoreScript   *redis.Script
+	extendJobScript   *redis.Script
+	progressScript    *redis.Script
+	cancelJobScript   *redis.Script
+	deleteJobScript   *redis.Script
 )
 
 func updateJobStatus(
@@ -18,34 +26,162 @@ func updateJobStatus(
 	key, jid, status string,
 	expire int,
 ) (int, error) {
-	return updateJobStatusAt(conn, key, jid, status, expire, time.Now(), "")
+	return updateJobStatusAt(conn, key, jid, status, expire, time.Now(), "",
+		"")
 }
 
-func updateJobStatusWithResult(
+func updateJobStatusAt(
 	conn redis.Conn,
 	key, jid, status string,
 	expire int,
-	result string,
+	updatedAt time.Time,
+	result, worker string,
 ) (int, error) {
-	return updateJobStatusAt(conn, key, jid, status, expire, time.Now(), result)
+	updatedMs := time2ms(updatedAt)
+	res, err := updateStateScript.Do(conn, 1, key,
+		jid, status, expire, updatedMs, result, worker, MaxAttempts)
+	return redis.Int(res, err)
 }
 
-func updateJobStatusAt(
+// setJobDesc atomically stores the given job descriptor, unless there is
+// another descriptor under the same key that cannot be overridden. In the
+// latter case the other descriptor is returned. If force is set, the
+// descriptor is stored unconditionally. Returns whether the stored
+// descriptor replaced another one.
+//
+// If the other job succeeded recently and is throttled, the given job is
+// either ignored (the other descriptor is returned) or deferred to the end
+// of the throttling window (the stored descriptor is returned).
+func setJobDesc(
 	conn redis.Conn,
-	key, jid, status string,
+	key string,
+	expire int,
+	descJson []byte,
+	force bool,
+	now time.Time,
+) (*JobDesc, bool, error) {
+	forceArg := 0
+	if force {
+		forceArg = 1
+	}
+
+	res, err := redis.Bytes(setJobDescScript.Do(conn, 1, key,
+		descJson, expire, forceArg, time2ms(now)))
+	if err != nil {
+		return nil, false, err
+	}
+
+	switch string(res) {
+	case "created":
+		return nil, false, nil
+	case "replaced":
+		return nil, true, nil
+	}
+
+	otherDesc := JobDesc{}
+	if err := json.Unmarshal(res, &otherDesc); err != nil {
+		return nil, false, err
+	}
+
+	return &otherDesc, false, nil
+}
+
+// postponeJob moves the start time of the job waiting to start.
+func postponeJob(
+	conn redis.Conn,
+	key, jid string,
+	runAt time.Time,
 	expire int,
-	updatedAt time.Time,
-	result string,
 ) (int, error) {
-	updatedMs := time2ms(updatedAt)
-	res, err := updateStateScript.Do(conn, 1, key,
-		jid, status, expire, updatedMs, result)
+	res, err := postponeJobScript.Do(conn, 1, key, jid, time2ms(runAt), expire)
 	return redis.Int(res, err)
 }
 
+// extendJob resets the expiration time of the descriptor of the executing
+// job.
+func extendJob(conn redis.Conn, key, jid string, expire int) (int, error) {
+	res, err := extendJobScript.Do(conn, 1, key, jid, expire)
+	return redis.Int(res, err)
+}
+
+// setJobProgress updates the progress of the executing job.
+func setJobProgress(
+	conn redis.Conn,
+	key, jid string,
+	progress float64,
+	message string,
+) (int, error) {
+	res, err := progressScript.Do(conn, 1, key, jid, progress, message)
+	return redis.Int(res, err)
+}
+
+// cancelJob marks the job not done yet as cancelled.
+func cancelJob(
+	conn redis.Conn,
+	key, jid string,
+	expire int,
+	cancelledAt time.Time,
+) (int, error) {
+	res, err := cancelJobScript.Do(conn, 1, key, jid, expire,
+		time2ms(cancelledAt))
+	return redis.Int(res, err)
+}
+
+// deleteJob removes the descriptor if it still belongs to the given JID,
+// whatever its status.
+func deleteJob(conn redis.Conn, key, jid string) (int, error) {
+	res, err := deleteJobScript.Do(conn, 1, key, jid)
+	return redis.Int(res, err)
+}
+
+// acquireSlot takes one of the given number of slots of the semaphore.
+func acquireSlot(
+	conn redis.Conn,
+	key, jid string,
+	limit, lease int,
+	now time.Time,
+) (bool, error) {
+	res, err := semaphoreScript.Do(conn, 1, key, jid, limit, lease,
+		time2ms(now))
+	return redis.Bool(res, err)
+}
+
+func releaseSlot(conn redis.Conn, key, jid string) error {
+	_, err := conn.Do("ZREM", key, jid)
+	return err
+}
+
 //go:embed update_status.lua
 var updateStatusScript string
 
+//go:embed enqueue.lua
+var enqueueScript string
+
+//go:embed postpone.lua
+var postponeScript string
+
+//go:embed acquire_slot.lua
+var acquireSlotScript string
+
+//go:embed extend.lua
+var extendScript string
+
+//go:embed progress.lua
+var setProgressScript string
+
+//go:embed cancel.lua
+var cancelScript string
+
+//go:embed delete.lua
+var deleteScript string
+
 func init() {
 	updateStateScript = redis.NewScript(-1, updateStatusScript)
+	setJobDescScript = redis.NewScript(-1, enqueueScript)
+	postponeJobScript = redis.NewScript(-1, postponeScript)
+	semaphoreScript = redis.NewScript(-1, acquireSlotScript)
+	extendJobScript = redis.NewScript(-1, extendScript)
+	progressScript = redis.NewScript(-1, setProgressScript)
+	cancelJobScript = redis.NewScript(-1, cancelScript)
+	deleteJobScript = redis.NewScript(-1, deleteScript)
 }
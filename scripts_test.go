@@ -1,6 +1,7 @@
 package once
 
 import (
+	"fmt"
 	"testing"
 	"time"
 
@@ -30,7 +31,7 @@ func TestUpdateJobStatus(t *testing.T) {
 
 		{
 			res, err := updateJobStatusAt(conn, key, "1", "BEGALA", 10,
-				time.Unix(1, 1e6), "-result-")
+				time.Unix(1, 1e6), "-result-", "")
 			Ω(err).Should(BeNil())
 			Ω(res).Should(Equal(0))
 		}
@@ -67,7 +68,7 @@ func TestUpdateJobStatus(t *testing.T) {
 
 		{
 			res, err := updateJobStatusAt(conn, key, "1", "BEGALA", 10,
-				time.Unix(1, 1e6), "")
+				time.Unix(1, 1e6), "", "")
 			Ω(err).Should(BeNil())
 			Ω(res).Should(Equal(0))
 		}
@@ -90,6 +91,77 @@ func TestUpdateJobStatus(t *testing.T) {
 	})
 }
 
+func TestUpdateJobStatus_Attempts(t *testing.T) {
+	RegisterTestingT(t)
+
+	setupRedis()
+	defer cleanRedis()
+
+	conn := workers.Config.Pool.Get()
+	defer conn.Close()
+
+	key := "test-key:attempts"
+
+	{
+		res, err := redis.String(conn.Do("SET", key, `{"jid":"1"}`))
+		Ω(err).Should(BeNil())
+		Ω(res).Should(Equal("OK"))
+	}
+
+	for i := 1; i <= MaxAttempts+2; i++ {
+		status, result := StatusRetryWaiting, fmt.Sprintf("boom %d", i)
+		if i == MaxAttempts+2 {
+			status, result = StatusOK, "42"
+		}
+
+		res, err := updateJobStatusAt(conn, key, "1", StatusExecuting, 10,
+			time.Unix(int64(i*10), 0), "", fmt.Sprintf("worker-%d", i))
+		Ω(err).Should(BeNil())
+		Ω(res).Should(Equal(0))
+
+		res, err = updateJobStatusAt(conn, key, "1", status, 10,
+			time.Unix(int64(i*10+1), 0), result, "")
+		Ω(err).Should(BeNil())
+		Ω(res).Should(Equal(0))
+	}
+
+	{
+		desc, err := getDescriptor(conn, key)
+		Ω(err).Should(BeNil())
+		Ω(desc.Status).Should(Equal(StatusOK))
+		Ω(desc.Result).Should(Equal("42"))
+
+		// Only the last attempts are kept
+		Ω(desc.Attempts).Should(HaveLen(MaxAttempts))
+		Ω(desc.Attempts[0]).Should(Equal(Attempt{
+			StartMs: 30000,
+			EndMs:   31000,
+			Outcome: StatusRetryWaiting,
+			Error:   "boom 3",
+			Worker:  "worker-3",
+		}))
+		Ω(desc.Attempts[MaxAttempts-1]).Should(Equal(Attempt{
+			StartMs: 120000,
+			EndMs:   121000,
+			Outcome: StatusOK,
+			Worker:  "worker-12",
+		}))
+	}
+
+	{
+		// An attempt is ended once
+		res, err := updateJobStatusAt(conn, key, "1", StatusFailed, 10,
+			time.Unix(200, 0), "late", "")
+		Ω(err).Should(BeNil())
+		Ω(res).Should(Equal(0))
+
+		desc, err := getDescriptor(conn, key)
+		Ω(err).Should(BeNil())
+		Ω(desc.Attempts[MaxAttempts-1].Outcome).Should(Equal(StatusOK))
+		Ω(desc.Attempts[MaxAttempts-1].EndMs).Should(Equal(int64(121000)))
+	}
+}
+
 func TestUpdateJobStatus_NoKey(t *testing.T) {
 	RegisterTestingT(t)
 
@@ -109,7 +181,7 @@ func TestUpdateJobStatus_NoKey(t *testing.T) {
 
 	{
 		res, err := updateJobStatusAt(conn, key, "1", "PRIGALA", 10,
-			time.Unix(1, 1e6), "")
+			time.Unix(1, 1e6), "", "")
 		Ω(err).Should(BeNil())
 		Ω(res).Should(Equal(-1))
 	}
@@ -141,7 +213,7 @@ func TestUpdateJobStatus_WrongJID(t *testing.T) {
 
 	{
 		res, err := updateJobStatusAt(conn, key, "–", "POLZALA", 10,
-			time.Unix(1, 1e6), "")
+			time.Unix(1, 1e6), "", "")
 		Ω(err).Should(BeNil())
 		Ω(res).Should(Equal(-2))
 	}
@@ -158,3 +230,642 @@ func TestUpdateJobStatus_WrongJID(t *testing.T) {
 		Ω(res).Should(Equal(-1))
 	}
 }
+
+func TestSetJobDesc(t *testing.T) {
+	conn := workers.Config.Pool.Get()
+	defer conn.Close()
+
+	val := `{"jid":"1"}`
+
+	t.Run("Force", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		setupRedis()
+		defer cleanRedis()
+
+		key := "test-key:set-job-desc:force"
+		oldval := `{"jid":"123","status":"init-waiting"}`
+
+		{
+			res, err := redis.String(conn.Do("SET", key, oldval))
+			Ω(err).Should(BeNil())
+			Ω(res).Should(Equal("OK"))
+		}
+
+		{
+			desc, replaced, err := setJobDesc(conn, key, 10, []byte(val), true,
+				time.Now())
+			Ω(err).Should(BeNil())
+			Ω(desc).Should(BeNil())
+			Ω(replaced).Should(BeTrue())
+		}
+
+		{
+			_, err := conn.Do("DEL", key)
+			Ω(err).Should(BeNil())
+
+			desc, replaced, err := setJobDesc(conn, key, 10, []byte(val), true,
+				time.Now())
+			Ω(err).Should(BeNil())
+			Ω(desc).Should(BeNil())
+			Ω(replaced).Should(BeFalse())
+		}
+
+		{
+			res, err := redis.String(conn.Do("GET", key))
+			Ω(err).Should(BeNil())
+			Ω(res).Should(Equal(val))
+		}
+
+		{
+			res, err := redis.Int(conn.Do("TTL", key))
+			Ω(err).Should(BeNil())
+			Ω(res).Should(Equal(10))
+		}
+	})
+
+	t.Run("Null Options", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		setupRedis()
+		defer cleanRedis()
+
+		key := "test-key:set-job-desc:null-options"
+		oldval := `{"jid":"123","status":"executing","options":null}`
+
+		{
+			res, err := redis.String(conn.Do("SET", key, oldval))
+			Ω(err).Should(BeNil())
+			Ω(res).Should(Equal("OK"))
+		}
+
+		{
+			desc, _, err := setJobDesc(conn, key, 10, []byte(val), false, time.Now())
+			Ω(err).Should(BeNil())
+			Ω(desc).Should(Equal(&JobDesc{Jid: "123", Status: StatusExecuting}))
+		}
+
+		{
+			res, err := redis.String(conn.Do("GET", key))
+			Ω(err).Should(BeNil())
+			Ω(res).Should(Equal(oldval))
+		}
+	})
+
+	t.Run("Not An Object", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		setupRedis()
+		defer cleanRedis()
+
+		key := "test-key:set-job-desc:not-an-object"
+
+		{
+			res, err := redis.String(conn.Do("SET", key, `"jid"`))
+			Ω(err).Should(BeNil())
+			Ω(res).Should(Equal("OK"))
+		}
+
+		{
+			desc, _, err := setJobDesc(conn, key, 10, []byte(val), false, time.Now())
+			Ω(err).Should(BeNil())
+			Ω(desc).Should(BeNil())
+		}
+
+		{
+			res, err := redis.String(conn.Do("GET", key))
+			Ω(err).Should(BeNil())
+			Ω(res).Should(Equal(val))
+		}
+	})
+}
+
+func TestUpdateJobStatus_Postponed(t *testing.T) {
+	RegisterTestingT(t)
+
+	setupRedis()
+	defer cleanRedis()
+
+	conn := workers.Config.Pool.Get()
+	defer conn.Close()
+
+	key := "test-key:postponed"
+	val := `{"jid":"1","status":"init-waiting","run_at_ms":2000}`
+
+	{
+		res, err := redis.String(conn.Do("SET", key, val))
+		Ω(err).Should(BeNil())
+		Ω(res).Should(Equal("OK"))
+	}
+
+	{
+		res, err := updateJobStatusAt(conn, key, "1", StatusExecuting, 10,
+			time.Unix(1, 0), "", "")
+		Ω(err).Should(BeNil())
+		Ω(res).Should(Equal(-3))
+	}
+
+	{
+		res, err := redis.String(conn.Do("GET", key))
+		Ω(err).Should(BeNil())
+		Ω(res).Should(Equal(val))
+	}
+
+	{
+		res, err := updateJobStatusAt(conn, key, "1", StatusExecuting, 10,
+			time.Unix(2, 0), "", "")
+		Ω(err).Should(BeNil())
+		Ω(res).Should(Equal(0))
+	}
+}
+
+func TestPostponeJob(t *testing.T) {
+	conn := workers.Config.Pool.Get()
+	defer conn.Close()
+
+	key := "test-key:postpone"
+
+	t.Run("Postpones", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		setupRedis()
+		defer cleanRedis()
+
+		{
+			res, err := redis.String(conn.Do("SET", key,
+				`{"jid":"1","status":"init-waiting","run_at_ms":1000}`))
+			Ω(err).Should(BeNil())
+			Ω(res).Should(Equal("OK"))
+		}
+
+		{
+			res, err := postponeJob(conn, key, "1", time.Unix(2, 0), 10)
+			Ω(err).Should(BeNil())
+			Ω(res).Should(Equal(0))
+		}
+
+		{
+			res, err := redis.String(conn.Do("GET", key))
+			Ω(err).Should(BeNil())
+			Ω(res).Should(MatchJSON(
+				`{"jid":"1","status":"init-waiting","run_at_ms":2000}`))
+		}
+
+		{
+			res, err := redis.Int(conn.Do("TTL", key))
+			Ω(err).Should(BeNil())
+			Ω(res).Should(Equal(10))
+		}
+
+		{
+			// Never moves backwards
+			res, err := postponeJob(conn, key, "1", time.Unix(1, 0), 20)
+			Ω(err).Should(BeNil())
+			Ω(res).Should(Equal(0))
+		}
+
+		{
+			res, err := redis.String(conn.Do("GET", key))
+			Ω(err).Should(BeNil())
+			Ω(res).Should(MatchJSON(
+				`{"jid":"1","status":"init-waiting","run_at_ms":2000}`))
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		setupRedis()
+		defer cleanRedis()
+
+		{
+			res, err := postponeJob(conn, key, "1", time.Unix(2, 0), 10)
+			Ω(err).Should(BeNil())
+			Ω(res).Should(Equal(-1))
+		}
+
+		{
+			res, err := redis.String(conn.Do("SET", key,
+				`{"jid":"1","status":"executing"}`))
+			Ω(err).Should(BeNil())
+			Ω(res).Should(Equal("OK"))
+		}
+
+		{
+			res, err := postponeJob(conn, key, "–", time.Unix(2, 0), 10)
+			Ω(err).Should(BeNil())
+			Ω(res).Should(Equal(-2))
+		}
+
+		{
+			res, err := postponeJob(conn, key, "1", time.Unix(2, 0), 10)
+			Ω(err).Should(BeNil())
+			Ω(res).Should(Equal(-3))
+		}
+
+		{
+			res, err := redis.String(conn.Do("GET", key))
+			Ω(err).Should(BeNil())
+			Ω(res).Should(MatchJSON(`{"jid":"1","status":"executing"}`))
+		}
+	})
+}
+
+func TestSetJobDesc_Throttled(t *testing.T) {
+	conn := workers.Config.Pool.Get()
+	defer conn.Close()
+
+	val := `{"jid":"1","status":"init-waiting"}`
+	now := time.Unix(100, 0)
+
+	t.Run("Drop", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		setupRedis()
+		defer cleanRedis()
+
+		key := "test-key:set-job-desc:throttled:drop"
+		oldval := `{"jid":"123","status":"ok","updated_ms":95000,` +
+			`"options":{"override_started":true,"throttle_window":10}}`
+
+		{
+			res, err := redis.String(conn.Do("SET", key, oldval))
+			Ω(err).Should(BeNil())
+			Ω(res).Should(Equal("OK"))
+		}
+
+		{
+			desc, _, err := setJobDesc(conn, key, 10, []byte(val), false, now)
+			Ω(err).Should(BeNil())
+			Ω(desc.Jid).Should(Equal("123"))
+		}
+
+		{
+			res, err := redis.String(conn.Do("GET", key))
+			Ω(err).Should(BeNil())
+			Ω(res).Should(Equal(oldval))
+		}
+
+		{
+			// The window is over
+			desc, _, err := setJobDesc(conn, key, 10, []byte(val), false,
+				time.Unix(105, 0))
+			Ω(err).Should(BeNil())
+			Ω(desc).Should(BeNil())
+		}
+
+		{
+			res, err := redis.String(conn.Do("GET", key))
+			Ω(err).Should(BeNil())
+			Ω(res).Should(Equal(val))
+		}
+	})
+
+	t.Run("Defer", func(t *testing.T) {
+		RegisterTestingT(t)
+
+		setupRedis()
+		defer cleanRedis()
+
+		key := "test-key:set-job-desc:throttled:defer"
+		oldval := `{"jid":"123","status":"ok","updated_ms":95000,` +
+			`"options":{"throttle_window":10,"throttle_defer":true}}`
+
+		{
+			res, err := redis.String(conn.Do("SET", key, oldval))
+			Ω(err).Should(BeNil())
+			Ω(res).Should(Equal("OK"))
+		}
+
+		{
+			desc, _, err := setJobDesc(conn, key, 10, []byte(val), false, now)
+			Ω(err).Should(BeNil())
+			Ω(desc).Should(Equal(&JobDesc{
+				Jid:     "1",
+				Status:  StatusInitWaiting,
+				RunAtMs: 105000,
+			}))
+		}
+
+		{
+			res, err := redis.String(conn.Do("GET", key))
+			Ω(err).Should(BeNil())
+			Ω(res).Should(MatchJSON(
+				`{"jid":"1","status":"init-waiting","run_at_ms":105000}`))
+		}
+
+		{
+			res, err := redis.Int(conn.Do("TTL", key))
+			Ω(err).Should(BeNil())
+			Ω(res).Should(Equal(15))
+		}
+
+		{
+			// The deferred job blocks the others
+			desc, _, err := setJobDesc(conn, key, 10, []byte(`{"jid":"2"}`),
+				false, now)
+			Ω(err).Should(BeNil())
+			Ω(desc.Jid).Should(Equal("1"))
+		}
+	})
+}
+
+func TestAcquireSlot(t *testing.T) {
+	RegisterTestingT(t)
+
+	setupRedis()
+	defer cleanRedis()
+
+	conn := workers.Config.Pool.Get()
+	defer conn.Close()
+
+	key := "test-key:sem"
+	now := time.Now()
+
+	{
+		ok, err := acquireSlot(conn, key, "1", 2, 10, now)
+		Ω(err).Should(BeNil())
+		Ω(ok).Should(BeTrue())
+	}
+
+	{
+		ok, err := acquireSlot(conn, key, "2", 2, 10, now)
+		Ω(err).Should(BeNil())
+		Ω(ok).Should(BeTrue())
+	}
+
+	{
+		// No slots left
+		ok, err := acquireSlot(conn, key, "3", 2, 10, now)
+		Ω(err).Should(BeNil())
+		Ω(ok).Should(BeFalse())
+	}
+
+	{
+		// The holder can renew its lease
+		ok, err := acquireSlot(conn, key, "1", 2, 20, now)
+		Ω(err).Should(BeNil())
+		Ω(ok).Should(BeTrue())
+	}
+
+	{
+		// The lease of "2" is expired by now
+		ok, err := acquireSlot(conn, key, "3", 2, 10, now.Add(15*time.Second))
+		Ω(err).Should(BeNil())
+		Ω(ok).Should(BeTrue())
+	}
+
+	{
+		res, err := redis.Strings(conn.Do("ZRANGE", key, 0, -1))
+		Ω(err).Should(BeNil())
+		Ω(res).Should(ConsistOf("1", "3"))
+	}
+
+	{
+		err := releaseSlot(conn, key, "1")
+		Ω(err).Should(BeNil())
+
+		ok, err := acquireSlot(conn, key, "4", 2, 10, now)
+		Ω(err).Should(BeNil())
+		Ω(ok).Should(BeTrue())
+	}
+
+	{
+		res, err := redis.Int(conn.Do("PTTL", key))
+		Ω(err).Should(BeNil())
+		Ω(res).Should(BeNumerically(">", 0))
+	}
+}
+
+func TestExtendJob(t *testing.T) {
+	RegisterTestingT(t)
+
+	setupRedis()
+	defer cleanRedis()
+
+	conn := workers.Config.Pool.Get()
+	defer conn.Close()
+
+	key := "test-key:extend"
+
+	{
+		res, err := extendJob(conn, key, "1", 10)
+		Ω(err).Should(BeNil())
+		Ω(res).Should(Equal(-1))
+	}
+
+	{
+		res, err := redis.String(conn.Do("SET", key,
+			`{"jid":"1","status":"init-waiting"}`, "EX", 5))
+		Ω(err).Should(BeNil())
+		Ω(res).Should(Equal("OK"))
+	}
+
+	{
+		res, err := extendJob(conn, key, "2", 10)
+		Ω(err).Should(BeNil())
+		Ω(res).Should(Equal(-2))
+	}
+
+	{
+		res, err := extendJob(conn, key, "1", 10)
+		Ω(err).Should(BeNil())
+		Ω(res).Should(Equal(-3))
+	}
+
+	{
+		res, err := updateJobStatus(conn, key, "1", "executing", 5)
+		Ω(err).Should(BeNil())
+		Ω(res).Should(Equal(0))
+	}
+
+	{
+		res, err := extendJob(conn, key, "1", 10)
+		Ω(err).Should(BeNil())
+		Ω(res).Should(Equal(0))
+	}
+
+	{
+		res, err := redis.Int(conn.Do("TTL", key))
+		Ω(err).Should(BeNil())
+		Ω(res).Should(Equal(10))
+	}
+}
+
+func TestSetJobProgress(t *testing.T) {
+	RegisterTestingT(t)
+
+	setupRedis()
+	defer cleanRedis()
+
+	conn := workers.Config.Pool.Get()
+	defer conn.Close()
+
+	key := "test-key:progress"
+
+	{
+		res, err := setJobProgress(conn, key, "1", 10, "")
+		Ω(err).Should(BeNil())
+		Ω(res).Should(Equal(-1))
+	}
+
+	{
+		res, err := redis.String(conn.Do("SET", key,
+			`{"jid":"1","status":"init-waiting"}`, "EX", 20))
+		Ω(err).Should(BeNil())
+		Ω(res).Should(Equal("OK"))
+	}
+
+	{
+		res, err := setJobProgress(conn, key, "2", 10, "")
+		Ω(err).Should(BeNil())
+		Ω(res).Should(Equal(-2))
+	}
+
+	{
+		res, err := setJobProgress(conn, key, "1", 10, "")
+		Ω(err).Should(BeNil())
+		Ω(res).Should(Equal(-3))
+	}
+
+	{
+		res, err := updateJobStatus(conn, key, "1", "executing", 20)
+		Ω(err).Should(BeNil())
+		Ω(res).Should(Equal(0))
+	}
+
+	{
+		res, err := setJobProgress(conn, key, "1", 42.5, "transcoding")
+		Ω(err).Should(BeNil())
+		Ω(res).Should(Equal(0))
+	}
+
+	{
+		desc, err := getDescriptor(conn, key)
+		Ω(err).Should(BeNil())
+		Ω(desc.Progress).Should(Equal(42.5))
+		Ω(desc.ProgressMessage).Should(Equal("transcoding"))
+	}
+
+	{
+		// The expiration time is kept
+		res, err := redis.Int(conn.Do("TTL", key))
+		Ω(err).Should(BeNil())
+		Ω(res).Should(BeNumerically("~", 20, 1))
+	}
+
+	{
+		res, err := setJobProgress(conn, key, "1", 50, "")
+		Ω(err).Should(BeNil())
+		Ω(res).Should(Equal(0))
+
+		desc, err := getDescriptor(conn, key)
+		Ω(err).Should(BeNil())
+		Ω(desc.Progress).Should(Equal(50.0))
+		Ω(desc.ProgressMessage).Should(Equal(""))
+	}
+
+	{
+		// A new attempt starts from scratch
+		res, err := updateJobStatus(conn, key, "1", "retry-waiting", 20)
+		Ω(err).Should(BeNil())
+		Ω(res).Should(Equal(0))
+
+		res, err = updateJobStatus(conn, key, "1", "executing", 20)
+		Ω(err).Should(BeNil())
+		Ω(res).Should(Equal(0))
+
+		desc, err := getDescriptor(conn, key)
+		Ω(err).Should(BeNil())
+		Ω(desc.Progress).Should(Equal(0.0))
+	}
+}
+
+func TestCancelJob(t *testing.T) {
+	RegisterTestingT(t)
+
+	setupRedis()
+	defer cleanRedis()
+
+	conn := workers.Config.Pool.Get()
+	defer conn.Close()
+
+	key := "test-key:cancel"
+
+	{
+		res, err := cancelJob(conn, key, "1", 10, time.Unix(1, 0))
+		Ω(err).Should(BeNil())
+		Ω(res).Should(Equal(-1))
+	}
+
+	{
+		res, err := redis.String(conn.Do("SET", key,
+			`{"jid":"1","status":"executing"}`))
+		Ω(err).Should(BeNil())
+		Ω(res).Should(Equal("OK"))
+	}
+
+	{
+		res, err := cancelJob(conn, key, "2", 10, time.Unix(1, 0))
+		Ω(err).Should(BeNil())
+		Ω(res).Should(Equal(-2))
+	}
+
+	{
+		res, err := cancelJob(conn, key, "1", 10, time.Unix(1, 0))
+		Ω(err).Should(BeNil())
+		Ω(res).Should(Equal(0))
+	}
+
+	{
+		res, err := redis.String(conn.Do("GET", key))
+		Ω(err).Should(BeNil())
+		Ω(res).Should(MatchJSON(
+			`{"jid":"1","status":"cancelled","updated_ms":1000}`))
+	}
+
+	{
+		res, err := redis.Int(conn.Do("TTL", key))
+		Ω(err).Should(BeNil())
+		Ω(res).Should(Equal(10))
+	}
+
+	{
+		res, err := cancelJob(conn, key, "1", 10, time.Unix(1, 0))
+		Ω(err).Should(BeNil())
+		Ω(res).Should(Equal(-3))
+	}
+
+	{
+		// The status of the cancelled job sticks
+		res, err := updateJobStatus(conn, key, "1", "ok", 10)
+		Ω(err).Should(BeNil())
+		Ω(res).Should(Equal(-4))
+	}
+
+	{
+		// But the cancelled job can be deleted
+		n, err := redis.Int(conn.Do("EXISTS", key))
+		Ω(err).Should(BeNil())
+		Ω(n).Should(Equal(1))
+
+		res, err := deleteJob(conn, key, "1")
+		Ω(err).Should(BeNil())
+		Ω(res).Should(Equal(0))
+
+		n, err = redis.Int(conn.Do("EXISTS", key))
+		Ω(err).Should(BeNil())
+		Ω(n).Should(Equal(0))
+
+		_, err = conn.Do("SET", key, `{"jid":"1","status":"cancelled"}`)
+		Ω(err).Should(BeNil())
+	}
+
+	{
+		// The cancelled job is overridden
+		desc, _, err := setJobDesc(conn, key, 10, []byte(`{"jid":"3"}`), false,
+			time.Now())
+		Ω(err).Should(BeNil())
+		Ω(desc).Should(BeNil())
+	}
+}
@@ -4,9 +4,10 @@ import (
 	"os"
 
 	"github.com/PlanitarInc/go-workers"
+	"github.com/gomodule/redigo/redis"
 )
 
-func setupRedis() {
+func redisAddr() string {
 	redisHost := "localhost"
 	redisPort := "6379"
 
@@ -17,8 +18,12 @@ func setupRedis() {
 		redisPort = val
 	}
 
+	return redisHost + ":" + redisPort
+}
+
+func setupRedis() {
 	workers.Configure(map[string]string{
-		"server":    redisHost + ":" + redisPort,
+		"server":    redisAddr(),
 		"process":   "1",
 		"database":  "15",
 		"pool":      "1",
@@ -31,3 +36,16 @@ func cleanRedis() {
 	conn.Do("flushdb")
 	conn.Close()
 }
+
+// newTestClient returns a client with its own pool, connected to the same
+// database as the one configured by setupRedis.
+func newTestClient(namespace string) *Client {
+	pool := &redis.Pool{
+		MaxIdle: 1,
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", redisAddr(), redis.DialDatabase(15))
+		},
+	}
+
+	return NewClient(pool, namespace)
+}
@@ -0,0 +1,448 @@
+package once
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps the job descriptors in the process memory. It is meant
+// for the applications and tests running the once semantics without a Redis
+// server; the descriptors are not shared with other processes.
+type MemoryStore struct {
+	mu      sync.Mutex
+	entries map[string]*memoryEntry
+	subs    map[string]map[*queuedSubscription]struct{}
+	// Semaphore slots: JIDs mapped to their lease expiration times
+	slots map[string]map[string]time.Time
+}
+
+type memoryEntry struct {
+	desc  *JobDesc
+	timer *time.Timer
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		entries: map[string]*memoryEntry{},
+		subs:    map[string]map[*queuedSubscription]struct{}{},
+		slots:   map[string]map[string]time.Time{},
+	}
+}
+
+func (s *MemoryStore) Create(
+	ctx context.Context,
+	key string,
+	desc *JobDesc,
+	expire int,
+	force bool,
+) (*JobDesc, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, exists := s.entries[key]
+	if exists && !force {
+		now := time.Now()
+		if windowEnd, ok := e.desc.ThrottledUntil(now); ok {
+			if !e.desc.Options.ThrottleDefer {
+				return cloneJobDesc(e.desc), false, nil
+			}
+
+			deferred := cloneJobDesc(desc)
+			deferred.RunAtMs = time2ms(windowEnd)
+			expire += int(math.Ceil(windowEnd.Sub(now).Seconds()))
+			s.set(key, deferred, expire)
+			s.publish(key, EventCreated, deferred)
+			return cloneJobDesc(deferred), true, nil
+		}
+
+		if !e.desc.CanBeOverridden() {
+			return cloneJobDesc(e.desc), false, nil
+		}
+	}
+
+	s.set(key, cloneJobDesc(desc), expire)
+	s.publish(key, EventCreated, desc)
+	return nil, exists, nil
+}
+
+func (s *MemoryStore) UpdateStatus(
+	ctx context.Context,
+	key, jid, status string,
+	expire int,
+	updatedAt time.Time,
+	result, worker string,
+) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[key]
+	if !ok {
+		return -1, nil
+	}
+	if e.desc.Jid != jid {
+		return -2, nil
+	}
+	cancelled := e.desc.IsCancelled()
+	if cancelled && status != StatusCancelled {
+		return -4, nil
+	}
+
+	// A postponed (debounced) job cannot start before its time
+	if status == StatusExecuting && e.desc.IsInitWaiting() &&
+		e.desc.RunAtMs > time2ms(updatedAt) {
+		return -3, nil
+	}
+
+	desc := cloneJobDesc(e.desc)
+	// Every attempt reports its own progress
+	if status == StatusExecuting {
+		desc.Progress = 0
+		desc.ProgressMessage = ""
+	}
+	updateAttempts(desc, status, time2ms(updatedAt), result, worker)
+	desc.Status = status
+	desc.UpdatedMs = time2ms(updatedAt)
+	if result != "" || status == StatusOK {
+		// The error of the failed attempt is kept in the attempts
+		desc.Result = result
+	}
+
+	s.set(key, desc, expire)
+	if !cancelled {
+		s.publish(key, EventStatus, desc)
+	}
+
+	return 0, nil
+}
+
+func (s *MemoryStore) Postpone(
+	ctx context.Context,
+	key, jid string,
+	runAt time.Time,
+	expire int,
+) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[key]
+	if !ok {
+		return -1, nil
+	}
+	if e.desc.Jid != jid {
+		return -2, nil
+	}
+	if !e.desc.IsInitWaiting() {
+		return -3, nil
+	}
+
+	desc := cloneJobDesc(e.desc)
+	// Never move the start time backwards
+	if runAtMs := time2ms(runAt); desc.RunAtMs < runAtMs {
+		desc.RunAtMs = runAtMs
+	}
+
+	s.set(key, desc, expire)
+	return 0, nil
+}
+
+func (s *MemoryStore) Extend(
+	ctx context.Context,
+	key, jid string,
+	expire int,
+) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[key]
+	if !ok {
+		return -1, nil
+	}
+	if e.desc.Jid != jid {
+		return -2, nil
+	}
+	if e.desc.Status != StatusExecuting {
+		return -3, nil
+	}
+
+	s.set(key, e.desc, expire)
+	return 0, nil
+}
+
+func (s *MemoryStore) SetProgress(
+	ctx context.Context,
+	key, jid string,
+	progress float64,
+	message string,
+) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[key]
+	if !ok {
+		return -1, nil
+	}
+	if e.desc.Jid != jid {
+		return -2, nil
+	}
+	if e.desc.Status != StatusExecuting {
+		return -3, nil
+	}
+
+	desc := cloneJobDesc(e.desc)
+	desc.Progress = progress
+	desc.ProgressMessage = message
+
+	// Keep the expiration time
+	e.desc = desc
+	s.publish(key, EventProgress, desc)
+
+	return 0, nil
+}
+
+func (s *MemoryStore) Cancel(
+	ctx context.Context,
+	key, jid string,
+	expire int,
+	cancelledAt time.Time,
+) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[key]
+	if !ok {
+		return -1, nil
+	}
+	if e.desc.Jid != jid {
+		return -2, nil
+	}
+	if e.desc.IsDone() {
+		return -3, nil
+	}
+
+	desc := cloneJobDesc(e.desc)
+	desc.Status = StatusCancelled
+	desc.UpdatedMs = time2ms(cancelledAt)
+
+	s.set(key, desc, expire)
+	s.publish(key, EventStatus, desc)
+
+	return 0, nil
+}
+
+func (s *MemoryStore) Get(ctx context.Context, key string) (*JobDesc, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[key]
+	if !ok {
+		return nil, NoMatchingJobsErr
+	}
+
+	return cloneJobDesc(e.desc), nil
+}
+
+// Scan returns the descriptors ordered by their keys, the cursor is the
+// last key returned.
+func (s *MemoryStore) Scan(
+	ctx context.Context,
+	prefix, cursor string,
+	count int,
+) ([]*JobDesc, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := []string{}
+	for key := range s.entries {
+		if strings.HasPrefix(key, prefix) && key > cursor {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	next := ""
+	if count > 0 && len(keys) > count {
+		keys = keys[:count]
+		next = keys[count-1]
+	}
+
+	descs := []*JobDesc{}
+	for _, key := range keys {
+		descs = append(descs, cloneJobDesc(s.entries[key].desc))
+	}
+
+	return descs, next, nil
+}
+
+func (s *MemoryStore) Delete(ctx context.Context, key, jid string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e, ok := s.entries[key]; ok && e.desc.Jid == jid {
+		s.remove(key)
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) AcquireSlot(
+	ctx context.Context,
+	key, jid string,
+	limit, lease int,
+) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	slots := s.slots[key]
+	if slots == nil {
+		slots = map[string]time.Time{}
+		s.slots[key] = slots
+	}
+
+	// Release the slots whose leases expired
+	for slotJid, expiresAt := range slots {
+		if !expiresAt.After(now) {
+			delete(slots, slotJid)
+		}
+	}
+
+	if _, ok := slots[jid]; !ok && len(slots) >= limit {
+		return false, nil
+	}
+
+	slots[jid] = now.Add(time.Duration(lease) * time.Second)
+	return true, nil
+}
+
+func (s *MemoryStore) ReleaseSlot(ctx context.Context, key, jid string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.slots[key], jid)
+	if len(s.slots[key]) == 0 {
+		delete(s.slots, key)
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) Subscribe(
+	ctx context.Context,
+	keys ...string,
+) (Subscription, error) {
+	sub := newQueuedSubscription()
+	sub.onClose = func() { s.unsubscribe(sub, keys) }
+
+	s.mu.Lock()
+	for _, key := range keys {
+		if s.subs[key] == nil {
+			s.subs[key] = map[*queuedSubscription]struct{}{}
+		}
+		s.subs[key][sub] = struct{}{}
+	}
+	s.mu.Unlock()
+
+	return sub, nil
+}
+
+// set stores the descriptor; the caller must hold the lock. Similarly to
+// Redis, a non-positive expiration time removes the descriptor.
+func (s *MemoryStore) set(key string, desc *JobDesc, expire int) {
+	s.remove(key)
+	if expire <= 0 {
+		return
+	}
+
+	e := &memoryEntry{desc: desc}
+	e.timer = time.AfterFunc(time.Duration(expire)*time.Second, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		// The entry might have been replaced in the meantime
+		if s.entries[key] == e {
+			delete(s.entries, key)
+		}
+	})
+	s.entries[key] = e
+}
+
+// remove deletes the descriptor; the caller must hold the lock.
+func (s *MemoryStore) remove(key string) {
+	if e, ok := s.entries[key]; ok {
+		e.timer.Stop()
+		delete(s.entries, key)
+	}
+}
+
+// publish notifies the subscribers; the caller must hold the lock.
+func (s *MemoryStore) publish(key, event string, desc *JobDesc) {
+	for sub := range s.subs[key] {
+		sub.push(Notification{
+			Key:   key,
+			Event: event,
+			Desc:  cloneJobDesc(desc),
+		})
+	}
+}
+
+func (s *MemoryStore) unsubscribe(sub *queuedSubscription, keys []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, key := range keys {
+		delete(s.subs[key], sub)
+		if len(s.subs[key]) == 0 {
+			delete(s.subs, key)
+		}
+	}
+}
+
+// updateAttempts keeps the history of the attempts the same way
+// update_status.lua does.
+func updateAttempts(
+	desc *JobDesc,
+	status string,
+	nowMs int64,
+	result, worker string,
+) {
+	switch status {
+	case StatusExecuting:
+		desc.Attempts = append(desc.Attempts, Attempt{
+			StartMs: nowMs,
+			Worker:  worker,
+		})
+		if n := len(desc.Attempts); n > MaxAttempts {
+			desc.Attempts = desc.Attempts[n-MaxAttempts:]
+		}
+
+	case StatusOK, StatusFailed, StatusRetryWaiting, StatusTimedOut,
+		StatusCancelled:
+		n := len(desc.Attempts)
+		if n == 0 || desc.Attempts[n-1].EndMs != 0 {
+			return
+		}
+		attempt := &desc.Attempts[n-1]
+		attempt.EndMs = nowMs
+		attempt.Outcome = status
+		if status != StatusOK {
+			attempt.Error = result
+		}
+	}
+}
+
+func cloneJobDesc(desc *JobDesc) *JobDesc {
+	tmp := *desc
+	if desc.Options != nil {
+		opts := *desc.Options
+		tmp.Options = &opts
+	}
+	if desc.Attempts != nil {
+		tmp.Attempts = append([]Attempt{}, desc.Attempts...)
+	}
+
+	return &tmp
+}
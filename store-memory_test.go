@@ -0,0 +1,543 @@
+package once
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestMemoryStoreCreate(t *testing.T) {
+	RegisterTestingT(t)
+
+	ctx := context.Background()
+	s := NewMemoryStore()
+	key := "test-key:mem-create"
+
+	{
+		other, replaced, err := s.Create(ctx, key, &JobDesc{Jid: "1"}, 10,
+			false)
+		Ω(err).Should(BeNil())
+		Ω(other).Should(BeNil())
+		Ω(replaced).Should(BeFalse())
+	}
+
+	{
+		other, _, err := s.Create(ctx, key, &JobDesc{Jid: "2"}, 10, false)
+		Ω(err).Should(BeNil())
+		Ω(other).Should(Equal(&JobDesc{Jid: "1"}))
+	}
+
+	{
+		other, replaced, err := s.Create(ctx, key, &JobDesc{Jid: "3"}, 10,
+			true)
+		Ω(err).Should(BeNil())
+		Ω(other).Should(BeNil())
+		Ω(replaced).Should(BeTrue())
+	}
+
+	{
+		desc, err := s.Get(ctx, key)
+		Ω(err).Should(BeNil())
+		Ω(desc).Should(Equal(&JobDesc{Jid: "3"}))
+	}
+}
+
+func TestMemoryStoreCreate_OverrideStarted(t *testing.T) {
+	RegisterTestingT(t)
+
+	ctx := context.Background()
+	s := NewMemoryStore()
+	key := "test-key:mem-create:override-started"
+	opts := &Options{OverrideStarted: true}
+
+	{
+		other, _, err := s.Create(ctx, key, &JobDesc{
+			Jid:     "1",
+			Status:  StatusInitWaiting,
+			Options: opts,
+		}, 10, false)
+		Ω(err).Should(BeNil())
+		Ω(other).Should(BeNil())
+	}
+
+	{
+		other, _, err := s.Create(ctx, key, &JobDesc{Jid: "2"}, 10, false)
+		Ω(err).Should(BeNil())
+		Ω(other.Jid).Should(Equal("1"))
+	}
+
+	{
+		n, err := s.UpdateStatus(ctx, key, "1", StatusExecuting, 10,
+			time.Now(), "", "")
+		Ω(err).Should(BeNil())
+		Ω(n).Should(Equal(0))
+	}
+
+	{
+		other, _, err := s.Create(ctx, key, &JobDesc{Jid: "2"}, 10, false)
+		Ω(err).Should(BeNil())
+		Ω(other).Should(BeNil())
+	}
+}
+
+func TestMemoryStoreUpdateStatus(t *testing.T) {
+	RegisterTestingT(t)
+
+	ctx := context.Background()
+	s := NewMemoryStore()
+	key := "test-key:mem-update"
+
+	{
+		n, err := s.UpdateStatus(ctx, key, "1", "BEGALA", 10,
+			time.Unix(1, 1e6), "", "")
+		Ω(err).Should(BeNil())
+		Ω(n).Should(Equal(-1))
+	}
+
+	{
+		_, _, err := s.Create(ctx, key, &JobDesc{Jid: "1"}, 10, false)
+		Ω(err).Should(BeNil())
+	}
+
+	{
+		n, err := s.UpdateStatus(ctx, key, "–", "POLZALA", 10,
+			time.Unix(1, 1e6), "", "")
+		Ω(err).Should(BeNil())
+		Ω(n).Should(Equal(-2))
+	}
+
+	{
+		n, err := s.UpdateStatus(ctx, key, "1", "BEGALA", 10,
+			time.Unix(1, 1e6), "-result-", "")
+		Ω(err).Should(BeNil())
+		Ω(n).Should(Equal(0))
+	}
+
+	{
+		desc, err := s.Get(ctx, key)
+		Ω(err).Should(BeNil())
+		Ω(desc).Should(Equal(&JobDesc{
+			Jid:       "1",
+			Status:    "BEGALA",
+			UpdatedMs: 1001,
+			Result:    "-result-",
+		}))
+	}
+
+	{
+		// Expires immediately
+		n, err := s.UpdateStatus(ctx, key, "1", "BEGALA", 0,
+			time.Unix(1, 1e6), "", "")
+		Ω(err).Should(BeNil())
+		Ω(n).Should(Equal(0))
+	}
+
+	{
+		desc, err := s.Get(ctx, key)
+		Ω(err).Should(Equal(NoMatchingJobsErr))
+		Ω(desc).Should(BeNil())
+	}
+}
+
+func TestMemoryStoreUpdateStatus_Attempts(t *testing.T) {
+	RegisterTestingT(t)
+
+	ctx := context.Background()
+	s := NewMemoryStore()
+	key := "test-key:mem-attempts"
+
+	_, _, err := s.Create(ctx, key, &JobDesc{Jid: "1"}, 10, false)
+	Ω(err).Should(BeNil())
+
+	for i := 1; i <= MaxAttempts+1; i++ {
+		s.UpdateStatus(ctx, key, "1", StatusExecuting, 10,
+			time.Unix(int64(i*10), 0), "", "w")
+		s.UpdateStatus(ctx, key, "1", StatusRetryWaiting, 10,
+			time.Unix(int64(i*10+1), 0), "boom", "")
+	}
+	s.UpdateStatus(ctx, key, "1", StatusExecuting, 10, time.Unix(200, 0),
+		"", "w")
+
+	desc, err := s.Get(ctx, key)
+	Ω(err).Should(BeNil())
+	Ω(desc.Attempts).Should(HaveLen(MaxAttempts))
+	Ω(desc.Attempts[0]).Should(Equal(Attempt{
+		StartMs: 30000,
+		EndMs:   31000,
+		Outcome: StatusRetryWaiting,
+		Error:   "boom",
+		Worker:  "w",
+	}))
+	// The running attempt
+	Ω(desc.Attempts[MaxAttempts-1]).Should(Equal(Attempt{
+		StartMs: 200000,
+		Worker:  "w",
+	}))
+}
+
+func TestMemoryStoreExpire(t *testing.T) {
+	RegisterTestingT(t)
+
+	ctx := context.Background()
+	s := NewMemoryStore()
+	key := "test-key:mem-expire"
+
+	_, _, err := s.Create(ctx, key, &JobDesc{Jid: "1"}, 1, false)
+	Ω(err).Should(BeNil())
+
+	Eventually(func() error {
+		_, err := s.Get(ctx, key)
+		return err
+	}, 2*time.Second, 50*time.Millisecond).Should(Equal(NoMatchingJobsErr))
+}
+
+func TestMemoryStoreDelete(t *testing.T) {
+	RegisterTestingT(t)
+
+	ctx := context.Background()
+	s := NewMemoryStore()
+	key := "test-key:mem-delete"
+
+	_, _, err := s.Create(ctx, key, &JobDesc{Jid: "1"}, 10, false)
+	Ω(err).Should(BeNil())
+
+	{
+		Ω(s.Delete(ctx, key, "–")).Should(BeNil())
+		_, err := s.Get(ctx, key)
+		Ω(err).Should(BeNil())
+	}
+
+	{
+		Ω(s.Delete(ctx, key, "1")).Should(BeNil())
+		_, err := s.Get(ctx, key)
+		Ω(err).Should(Equal(NoMatchingJobsErr))
+	}
+}
+
+func TestMemoryStoreSubscribe(t *testing.T) {
+	RegisterTestingT(t)
+
+	ctx := context.Background()
+	s := NewMemoryStore()
+	key := "test-key:mem-subscribe"
+
+	sub, err := s.Subscribe(ctx, key)
+	Ω(err).Should(BeNil())
+
+	_, _, err = s.Create(ctx, key, &JobDesc{Jid: "1"}, 10, false)
+	Ω(err).Should(BeNil())
+
+	s.UpdateStatus(ctx, key, "1", StatusExecuting, 10, time.Unix(1, 0), "", "")
+	s.UpdateStatus(ctx, key, "1", StatusOK, 10, time.Unix(2, 0), "", "")
+
+	{
+		var n Notification
+		Eventually(sub.C()).Should(Receive(&n))
+		Ω(n.Err).Should(BeNil())
+		Ω(n.Event).Should(Equal(EventCreated))
+		Ω(n.Desc).Should(Equal(&JobDesc{Jid: "1"}))
+	}
+
+	{
+		var n Notification
+		Eventually(sub.C()).Should(Receive(&n))
+		Ω(n.Event).Should(Equal(EventStatus))
+		Ω(n.Desc).Should(Equal(&JobDesc{
+			Jid:       "1",
+			Status:    StatusExecuting,
+			UpdatedMs: 1000,
+			Attempts:  []Attempt{{StartMs: 1000}},
+		}))
+	}
+
+	{
+		var n Notification
+		Eventually(sub.C()).Should(Receive(&n))
+		Ω(n.Err).Should(BeNil())
+		Ω(n.Key).Should(Equal(key))
+		Ω(n.Event).Should(Equal(EventStatus))
+		Ω(n.Desc).Should(Equal(&JobDesc{
+			Jid:       "1",
+			Status:    StatusOK,
+			UpdatedMs: 2000,
+			Attempts: []Attempt{{
+				StartMs: 1000,
+				EndMs:   2000,
+				Outcome: StatusOK,
+			}},
+		}))
+	}
+
+	Ω(sub.Close()).Should(BeNil())
+	Eventually(sub.C()).Should(BeClosed())
+}
+
+func TestMemoryStorePostpone(t *testing.T) {
+	RegisterTestingT(t)
+
+	ctx := context.Background()
+	s := NewMemoryStore()
+	key := "test-key:mem-postpone"
+
+	{
+		n, err := s.Postpone(ctx, key, "1", time.Unix(2, 0), 10)
+		Ω(err).Should(BeNil())
+		Ω(n).Should(Equal(-1))
+	}
+
+	_, _, err := s.Create(ctx, key, &JobDesc{
+		Jid:     "1",
+		Status:  StatusInitWaiting,
+		RunAtMs: 1000,
+	}, 10, false)
+	Ω(err).Should(BeNil())
+
+	{
+		n, err := s.Postpone(ctx, key, "–", time.Unix(2, 0), 10)
+		Ω(err).Should(BeNil())
+		Ω(n).Should(Equal(-2))
+	}
+
+	{
+		n, err := s.Postpone(ctx, key, "1", time.Unix(2, 0), 10)
+		Ω(err).Should(BeNil())
+		Ω(n).Should(Equal(0))
+	}
+
+	{
+		// Cannot start before the postponed time
+		n, err := s.UpdateStatus(ctx, key, "1", StatusExecuting, 10,
+			time.Unix(1, 0), "", "")
+		Ω(err).Should(BeNil())
+		Ω(n).Should(Equal(-3))
+	}
+
+	{
+		n, err := s.UpdateStatus(ctx, key, "1", StatusExecuting, 10,
+			time.Unix(2, 0), "", "")
+		Ω(err).Should(BeNil())
+		Ω(n).Should(Equal(0))
+	}
+
+	{
+		n, err := s.Postpone(ctx, key, "1", time.Unix(3, 0), 10)
+		Ω(err).Should(BeNil())
+		Ω(n).Should(Equal(-3))
+	}
+}
+
+func TestMemoryStoreCreate_Throttled(t *testing.T) {
+	RegisterTestingT(t)
+
+	ctx := context.Background()
+	s := NewMemoryStore()
+	key := "test-key:mem-create:throttled"
+	updatedMs := time2ms(time.Now())
+
+	_, _, err := s.Create(ctx, key, &JobDesc{
+		Jid:       "1",
+		Status:    StatusOK,
+		UpdatedMs: updatedMs,
+		Options:   &Options{ThrottleWindow: 10},
+	}, 10, false)
+	Ω(err).Should(BeNil())
+
+	{
+		other, _, err := s.Create(ctx, key, &JobDesc{Jid: "2"}, 10, false)
+		Ω(err).Should(BeNil())
+		Ω(other.Jid).Should(Equal("1"))
+	}
+
+	_, _, err = s.Create(ctx, key, &JobDesc{
+		Jid:       "3",
+		Status:    StatusOK,
+		UpdatedMs: updatedMs,
+		Options:   &Options{ThrottleWindow: 10, ThrottleDefer: true},
+	}, 10, true)
+	Ω(err).Should(BeNil())
+
+	{
+		other, _, err := s.Create(ctx, key, &JobDesc{Jid: "4"}, 10, false)
+		Ω(err).Should(BeNil())
+		Ω(other).Should(Equal(&JobDesc{Jid: "4", RunAtMs: updatedMs + 10000}))
+	}
+
+	{
+		desc, err := s.Get(ctx, key)
+		Ω(err).Should(BeNil())
+		Ω(desc.Jid).Should(Equal("4"))
+	}
+}
+
+func TestMemoryStoreAcquireSlot(t *testing.T) {
+	RegisterTestingT(t)
+
+	ctx := context.Background()
+	s := NewMemoryStore()
+	key := "test-key:mem-sem"
+
+	{
+		ok, err := s.AcquireSlot(ctx, key, "1", 1, 10)
+		Ω(err).Should(BeNil())
+		Ω(ok).Should(BeTrue())
+	}
+
+	{
+		ok, err := s.AcquireSlot(ctx, key, "2", 1, 10)
+		Ω(err).Should(BeNil())
+		Ω(ok).Should(BeFalse())
+	}
+
+	{
+		ok, err := s.AcquireSlot(ctx, key, "1", 1, 10)
+		Ω(err).Should(BeNil())
+		Ω(ok).Should(BeTrue())
+	}
+
+	Ω(s.ReleaseSlot(ctx, key, "1")).Should(BeNil())
+
+	{
+		// Expires immediately
+		ok, err := s.AcquireSlot(ctx, key, "2", 1, 0)
+		Ω(err).Should(BeNil())
+		Ω(ok).Should(BeTrue())
+	}
+
+	{
+		ok, err := s.AcquireSlot(ctx, key, "3", 1, 10)
+		Ω(err).Should(BeNil())
+		Ω(ok).Should(BeTrue())
+	}
+}
+
+func TestMemoryStoreExtend(t *testing.T) {
+	RegisterTestingT(t)
+
+	ctx := context.Background()
+	s := NewMemoryStore()
+	key := "test-key:mem-extend"
+
+	_, _, err := s.Create(ctx, key, &JobDesc{
+		Jid:    "1",
+		Status: StatusExecuting,
+	}, 1, false)
+	Ω(err).Should(BeNil())
+
+	{
+		n, err := s.Extend(ctx, key, "2", 10)
+		Ω(err).Should(BeNil())
+		Ω(n).Should(Equal(-2))
+	}
+
+	{
+		n, err := s.Extend(ctx, key, "1", 10)
+		Ω(err).Should(BeNil())
+		Ω(n).Should(Equal(0))
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+
+	{
+		desc, err := s.Get(ctx, key)
+		Ω(err).Should(BeNil())
+		Ω(desc.Jid).Should(Equal("1"))
+	}
+}
+
+func TestMemoryStoreSetProgress(t *testing.T) {
+	RegisterTestingT(t)
+
+	ctx := context.Background()
+	s := NewMemoryStore()
+	key := "test-key:mem-progress"
+
+	_, _, err := s.Create(ctx, key, &JobDesc{
+		Jid:    "1",
+		Status: StatusInitWaiting,
+	}, 10, false)
+	Ω(err).Should(BeNil())
+
+	{
+		n, err := s.SetProgress(ctx, key, "1", 10, "")
+		Ω(err).Should(BeNil())
+		Ω(n).Should(Equal(-3))
+	}
+
+	{
+		n, err := s.UpdateStatus(ctx, key, "1", StatusExecuting, 10,
+			time.Unix(1, 0), "", "")
+		Ω(err).Should(BeNil())
+		Ω(n).Should(Equal(0))
+	}
+
+	sub, err := s.Subscribe(ctx, key)
+	Ω(err).Should(BeNil())
+	defer sub.Close()
+
+	{
+		n, err := s.SetProgress(ctx, key, "1", 42, "transcoding")
+		Ω(err).Should(BeNil())
+		Ω(n).Should(Equal(0))
+	}
+
+	{
+		var n Notification
+		Eventually(sub.C()).Should(Receive(&n))
+		Ω(n.Event).Should(Equal(EventProgress))
+		Ω(n.Desc.Progress).Should(Equal(42.0))
+		Ω(n.Desc.ProgressMessage).Should(Equal("transcoding"))
+	}
+
+	{
+		desc, err := s.Get(ctx, key)
+		Ω(err).Should(BeNil())
+		Ω(desc.Progress).Should(Equal(42.0))
+	}
+}
+
+func TestMemoryStoreCancel(t *testing.T) {
+	RegisterTestingT(t)
+
+	ctx := context.Background()
+	s := NewMemoryStore()
+	key := "test-key:mem-cancel"
+
+	_, _, err := s.Create(ctx, key, &JobDesc{
+		Jid:    "1",
+		Status: StatusInitWaiting,
+	}, 10, false)
+	Ω(err).Should(BeNil())
+
+	{
+		n, err := s.Cancel(ctx, key, "2", 10, time.Unix(1, 0))
+		Ω(err).Should(BeNil())
+		Ω(n).Should(Equal(-2))
+	}
+
+	{
+		n, err := s.Cancel(ctx, key, "1", 10, time.Unix(1, 0))
+		Ω(err).Should(BeNil())
+		Ω(n).Should(Equal(0))
+	}
+
+	{
+		n, err := s.Cancel(ctx, key, "1", 10, time.Unix(1, 0))
+		Ω(err).Should(BeNil())
+		Ω(n).Should(Equal(-3))
+	}
+
+	{
+		n, err := s.UpdateStatus(ctx, key, "1", StatusExecuting, 10,
+			time.Unix(2, 0), "", "")
+		Ω(err).Should(BeNil())
+		Ω(n).Should(Equal(-4))
+	}
+
+	{
+		desc, _, err := s.Create(ctx, key, &JobDesc{Jid: "3"}, 10, false)
+		Ω(err).Should(BeNil())
+		Ω(desc).Should(BeNil())
+	}
+}
@@ -0,0 +1,292 @@
+package once
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+const (
+	minResubscribeBackoff = 100 * time.Millisecond
+	maxResubscribeBackoff = 10 * time.Second
+)
+
+// redisSubscriber shares a single pub/sub connection among all the
+// subscriptions of a RedisStore. The channels are subscribed on demand and
+// unsubscribed once nobody listens to them; the connection is returned to
+// the pool when no channels are left. A lost connection is re-established
+// and the channels are subscribed again.
+type redisSubscriber struct {
+	pool *redis.Pool
+
+	// dialMu serializes establishing the connection.
+	dialMu sync.Mutex
+
+	mu sync.Mutex
+	// conn is nil while disconnected; otherwise a receiving goroutine
+	// reads it.
+	conn     *redis.PubSubConn
+	channels map[string]*redisChannel
+	// inflight counts the SUBSCRIBE commands not confirmed yet, per channel.
+	inflight map[string]int
+}
+
+type redisChannel struct {
+	subs map[*queuedSubscription]struct{}
+	// ready is closed once the channel is subscribed.
+	ready     chan struct{}
+	confirmed bool
+}
+
+func newRedisSubscriber(pool *redis.Pool) *redisSubscriber {
+	return &redisSubscriber{
+		pool:     pool,
+		channels: map[string]*redisChannel{},
+		inflight: map[string]int{},
+	}
+}
+
+func (s *redisSubscriber) Subscribe(
+	ctx context.Context,
+	keys ...string,
+) (Subscription, error) {
+	if err := s.connect(ctx); err != nil {
+		return nil, err
+	}
+
+	uniqueKeys := []string{}
+	seen := map[string]bool{}
+	for _, key := range keys {
+		if !seen[key] {
+			seen[key] = true
+			uniqueKeys = append(uniqueKeys, key)
+		}
+	}
+
+	sub := newQueuedSubscription()
+	sub.onClose = func() { s.unsubscribe(sub, uniqueKeys) }
+
+	s.mu.Lock()
+	ready := []chan struct{}{}
+	for _, key := range uniqueKeys {
+		c := s.channels[key]
+		if c == nil {
+			c = &redisChannel{
+				subs:  map[*queuedSubscription]struct{}{},
+				ready: make(chan struct{}),
+			}
+			s.channels[key] = c
+			if s.conn != nil {
+				s.subscribe(key)
+			}
+		}
+		c.subs[sub] = struct{}{}
+		ready = append(ready, c.ready)
+	}
+	connected := s.conn != nil
+	s.mu.Unlock()
+
+	// The connection might have been lost in the meantime
+	if !connected {
+		if err := s.connect(ctx); err != nil {
+			sub.Close()
+			return nil, err
+		}
+	}
+
+	for _, c := range ready {
+		select {
+		case <-c:
+		case <-ctx.Done():
+			sub.Close()
+			return nil, ctx.Err()
+		}
+	}
+
+	return sub, nil
+}
+
+// connect takes a connection from the pool unless connected already, and
+// subscribes all the channels on it.
+func (s *redisSubscriber) connect(ctx context.Context) error {
+	s.dialMu.Lock()
+	defer s.dialMu.Unlock()
+
+	s.mu.Lock()
+	connected := s.conn != nil
+	s.mu.Unlock()
+	if connected {
+		return nil
+	}
+
+	c, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	conn := &redis.PubSubConn{Conn: c}
+
+	s.mu.Lock()
+	s.conn = conn
+	s.inflight = map[string]int{}
+	for key := range s.channels {
+		s.subscribe(key)
+	}
+	s.mu.Unlock()
+
+	go s.receive(conn)
+
+	return nil
+}
+
+// subscribe sends SUBSCRIBE for the channel; the caller must hold the lock.
+// A failure shows up as a receiving error.
+func (s *redisSubscriber) subscribe(key string) {
+	s.inflight[key]++
+	s.conn.Subscribe(key)
+}
+
+func (s *redisSubscriber) unsubscribe(sub *queuedSubscription, keys []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, key := range keys {
+		c := s.channels[key]
+		if c == nil {
+			continue
+		}
+
+		delete(c.subs, sub)
+		if len(c.subs) == 0 {
+			delete(s.channels, key)
+			if s.conn != nil {
+				s.conn.Unsubscribe(key)
+			}
+		}
+	}
+}
+
+func (s *redisSubscriber) receive(conn *redis.PubSubConn) {
+	for {
+		switch v := conn.Receive().(type) {
+		case redis.Message:
+			s.publish(v)
+
+		case redis.Subscription:
+			if s.confirm(conn, v) {
+				return
+			}
+
+		case error:
+			s.disconnect(conn)
+			s.reconnect()
+			return
+		}
+	}
+}
+
+func (s *redisSubscriber) publish(msg redis.Message) {
+	n := Notification{Key: msg.Channel}
+	payload := struct {
+		JobDesc
+		Event string `json:"event"`
+	}{}
+	if err := json.Unmarshal(msg.Data, &payload); err != nil {
+		n.Err = err
+	} else {
+		n.Event = payload.Event
+		n.Desc = &payload.JobDesc
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if c := s.channels[msg.Channel]; c != nil {
+		for sub := range c.subs {
+			sub.push(n)
+		}
+	}
+}
+
+// confirm handles the (un)subscription replies. Returns true if the
+// connection is not needed anymore and was returned to the pool.
+func (s *redisSubscriber) confirm(
+	conn *redis.PubSubConn,
+	v redis.Subscription,
+) bool {
+	s.mu.Lock()
+
+	switch v.Kind {
+	case "subscribe":
+		// Only the last of the SUBSCRIBE commands sent for the channel
+		// guarantees it is subscribed.
+		s.inflight[v.Channel]--
+		if s.inflight[v.Channel] > 0 {
+			break
+		}
+		delete(s.inflight, v.Channel)
+
+		if c := s.channels[v.Channel]; c != nil && !c.confirmed {
+			c.confirmed = true
+			close(c.ready)
+		}
+
+	case "unsubscribe":
+		if v.Count > 0 || len(s.channels) > 0 || len(s.inflight) > 0 {
+			break
+		}
+
+		s.conn = nil
+		s.mu.Unlock()
+		conn.Close()
+		return true
+	}
+
+	s.mu.Unlock()
+	return false
+}
+
+// disconnect drops the broken connection; the channels have to be
+// subscribed again.
+func (s *redisSubscriber) disconnect(conn *redis.PubSubConn) {
+	s.mu.Lock()
+	if s.conn == conn {
+		s.conn = nil
+		for _, c := range s.channels {
+			if c.confirmed {
+				c.confirmed = false
+				c.ready = make(chan struct{})
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	conn.Close()
+}
+
+// reconnect re-establishes the connection as long as there are channels to
+// listen to. The messages published meanwhile are lost.
+func (s *redisSubscriber) reconnect() {
+	backoff := minResubscribeBackoff
+
+	for {
+		s.mu.Lock()
+		done := s.conn != nil || len(s.channels) == 0
+		s.mu.Unlock()
+		if done {
+			return
+		}
+
+		if err := s.connect(context.Background()); err == nil {
+			return
+		}
+
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > maxResubscribeBackoff {
+			backoff = maxResubscribeBackoff
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package once
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// RedisStore keeps the job descriptors in Redis. The descriptors are updated
+// by Lua scripts, so the updates are atomic.
+type RedisStore struct {
+	Pool *redis.Pool
+
+	subscriber     *redisSubscriber
+	subscriberOnce sync.Once
+}
+
+func NewRedisStore(pool *redis.Pool) *RedisStore {
+	return &RedisStore{Pool: pool}
+}
+
+func (s *RedisStore) Create(
+	ctx context.Context,
+	key string,
+	desc *JobDesc,
+	expire int,
+	force bool,
+) (*JobDesc, bool, error) {
+	conn, err := s.Pool.GetContext(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	defer conn.Close()
+
+	descJson, err := json.Marshal(desc)
+	if err != nil {
+		return nil, false, err
+	}
+
+	other, replaced, err := setJobDesc(conn, key, expire, descJson, force,
+		time.Now())
+	if other != nil && other.Jid == desc.Jid {
+		// Deferred to the end of the throttling window of the other job
+		replaced = true
+	}
+
+	return other, replaced, err
+}
+
+func (s *RedisStore) UpdateStatus(
+	ctx context.Context,
+	key, jid, status string,
+	expire int,
+	updatedAt time.Time,
+	result, worker string,
+) (int, error) {
+	conn, err := s.Pool.GetContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	return updateJobStatusAt(conn, key, jid, status, expire, updatedAt,
+		result, worker)
+}
+
+func (s *RedisStore) Postpone(
+	ctx context.Context,
+	key, jid string,
+	runAt time.Time,
+	expire int,
+) (int, error) {
+	conn, err := s.Pool.GetContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	return postponeJob(conn, key, jid, runAt, expire)
+}
+
+func (s *RedisStore) Extend(
+	ctx context.Context,
+	key, jid string,
+	expire int,
+) (int, error) {
+	conn, err := s.Pool.GetContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	return extendJob(conn, key, jid, expire)
+}
+
+func (s *RedisStore) SetProgress(
+	ctx context.Context,
+	key, jid string,
+	progress float64,
+	message string,
+) (int, error) {
+	conn, err := s.Pool.GetContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	return setJobProgress(conn, key, jid, progress, message)
+}
+
+func (s *RedisStore) Cancel(
+	ctx context.Context,
+	key, jid string,
+	expire int,
+	cancelledAt time.Time,
+) (int, error) {
+	conn, err := s.Pool.GetContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	return cancelJob(conn, key, jid, expire, cancelledAt)
+}
+
+func (s *RedisStore) Get(ctx context.Context, key string) (*JobDesc, error) {
+	conn, err := s.Pool.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	return getDescriptor(conn, key)
+}
+
+func (s *RedisStore) Delete(ctx context.Context, key, jid string) error {
+	conn, err := s.Pool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return unsetJobDesc(conn, key, jid)
+}
+
+func (s *RedisStore) Scan(
+	ctx context.Context,
+	prefix, cursor string,
+	count int,
+) ([]*JobDesc, string, error) {
+	conn, err := s.Pool.GetContext(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	defer conn.Close()
+
+	if cursor == "" {
+		cursor = "0"
+	}
+	res, err := redis.Values(conn.Do("SCAN", cursor,
+		"MATCH", escapeGlob(prefix)+"*", "COUNT", count))
+	if err != nil {
+		return nil, "", err
+	}
+
+	var keys []string
+	if _, err := redis.Scan(res, &cursor, &keys); err != nil {
+		return nil, "", err
+	}
+	if cursor == "0" {
+		cursor = ""
+	}
+	if len(keys) == 0 {
+		return nil, cursor, nil
+	}
+
+	vals, err := redis.ByteSlices(conn.Do("MGET",
+		redis.Args{}.AddFlat(keys)...))
+	if err != nil {
+		return nil, "", err
+	}
+
+	descs := []*JobDesc{}
+	for _, val := range vals {
+		// Expired meanwhile
+		if val == nil {
+			continue
+		}
+
+		desc := JobDesc{}
+		if err := json.Unmarshal(val, &desc); err != nil {
+			// Not a job descriptor, skip it
+			continue
+		}
+		descs = append(descs, &desc)
+	}
+
+	return descs, cursor, nil
+}
+
+func (s *RedisStore) AcquireSlot(
+	ctx context.Context,
+	key, jid string,
+	limit, lease int,
+) (bool, error) {
+	conn, err := s.Pool.GetContext(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	return acquireSlot(conn, key, jid, limit, lease, time.Now())
+}
+
+func (s *RedisStore) ReleaseSlot(ctx context.Context, key, jid string) error {
+	conn, err := s.Pool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return releaseSlot(conn, key, jid)
+}
+
+// Subscribe shares a single pub/sub connection among all the subscriptions
+// of the store. The job descriptors are published on the channels named
+// after their keys.
+func (s *RedisStore) Subscribe(
+	ctx context.Context,
+	keys ...string,
+) (Subscription, error) {
+	s.subscriberOnce.Do(func() {
+		s.subscriber = newRedisSubscriber(s.Pool)
+	})
+
+	return s.subscriber.Subscribe(ctx, keys...)
+}
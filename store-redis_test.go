@@ -0,0 +1,171 @@
+package once
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/PlanitarInc/go-workers"
+	"github.com/gomodule/redigo/redis"
+	. "github.com/onsi/gomega"
+)
+
+// newTrackingPool returns a pool remembering the network connections it
+// dialed, so the tests can break them.
+func newTrackingPool() (*redis.Pool, func() []net.Conn) {
+	var mu sync.Mutex
+	conns := []net.Conn{}
+
+	pool := &redis.Pool{
+		MaxIdle: 1,
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", redisAddr(), redis.DialDatabase(15),
+				redis.DialNetDial(func(network, addr string) (net.Conn, error) {
+					conn, err := net.Dial(network, addr)
+					if err == nil {
+						mu.Lock()
+						conns = append(conns, conn)
+						mu.Unlock()
+					}
+					return conn, err
+				}))
+		},
+	}
+
+	return pool, func() []net.Conn {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]net.Conn{}, conns...)
+	}
+}
+
+func TestRedisStoreSubscribe_SharedConnection(t *testing.T) {
+	RegisterTestingT(t)
+
+	setupRedis()
+	defer cleanRedis()
+
+	conn := workers.Config.Pool.Get()
+	defer conn.Close()
+
+	ctx := context.Background()
+	pool, _ := newTrackingPool()
+	s := NewRedisStore(pool)
+
+	subs := []Subscription{}
+	for i := 0; i < 20; i++ {
+		sub, err := s.Subscribe(ctx, fmt.Sprintf("test-key:shared-%d", i%10))
+		Ω(err).Should(BeNil())
+		subs = append(subs, sub)
+	}
+
+	Ω(pool.ActiveCount()).Should(Equal(1))
+
+	{
+		n, err := redis.Int(conn.Do("PUBLISH", "test-key:shared-3",
+			`{"jid":"3","status":"ok"}`))
+		Ω(err).Should(BeNil())
+		// A single connection listens to all the channels
+		Ω(n).Should(Equal(1))
+	}
+
+	for i, sub := range subs {
+		if i%10 != 3 {
+			Consistently(sub.C(), 10*time.Millisecond).ShouldNot(Receive())
+			continue
+		}
+
+		var n Notification
+		Eventually(sub.C()).Should(Receive(&n))
+		Ω(n.Key).Should(Equal("test-key:shared-3"))
+		Ω(n.Desc).Should(Equal(&JobDesc{Jid: "3", Status: StatusOK}))
+	}
+
+	{
+		// The other subscriber of the same channel keeps listening
+		Ω(subs[3].Close()).Should(BeNil())
+
+		_, err := conn.Do("PUBLISH", "test-key:shared-3",
+			`{"jid":"4","status":"ok"}`)
+		Ω(err).Should(BeNil())
+
+		var n Notification
+		Eventually(subs[13].C()).Should(Receive(&n))
+		Ω(n.Desc.Jid).Should(Equal("4"))
+	}
+
+	for _, sub := range subs {
+		Ω(sub.Close()).Should(BeNil())
+	}
+
+	// The connection is returned to the pool
+	Eventually(pool.ActiveCount).Should(Equal(1))
+	Eventually(pool.IdleCount).Should(Equal(1))
+
+	{
+		n, err := redis.Int(conn.Do("PUBLISH", "test-key:shared-3",
+			`{"jid":"5","status":"ok"}`))
+		Ω(err).Should(BeNil())
+		Ω(n).Should(Equal(0))
+	}
+}
+
+func TestRedisStoreSubscribe_Reconnect(t *testing.T) {
+	RegisterTestingT(t)
+
+	setupRedis()
+	defer cleanRedis()
+
+	conn := workers.Config.Pool.Get()
+	defer conn.Close()
+
+	ctx := context.Background()
+	pool, conns := newTrackingPool()
+	s := NewRedisStore(pool)
+
+	sub, err := s.Subscribe(ctx, "test-key:reconnect")
+	Ω(err).Should(BeNil())
+	defer sub.Close()
+
+	// Break the connection
+	Ω(conns()).Should(HaveLen(1))
+	conns()[0].Close()
+
+	// Subscribed again on a new connection; the messages published
+	// meanwhile are lost
+	var n Notification
+	Eventually(func() bool {
+		_, err := conn.Do("PUBLISH", "test-key:reconnect",
+			`{"jid":"1","status":"ok"}`)
+		Ω(err).Should(BeNil())
+
+		select {
+		case n = <-sub.C():
+			return true
+		case <-time.After(10 * time.Millisecond):
+			return false
+		}
+	}, 2*time.Second).Should(BeTrue())
+	Ω(n.Err).Should(BeNil())
+	Ω(n.Desc.Jid).Should(Equal("1"))
+	Ω(conns()).Should(HaveLen(2))
+}
+
+func TestRedisStoreSubscribe_NoConnection(t *testing.T) {
+	RegisterTestingT(t)
+
+	pool := &redis.Pool{
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", "127.0.0.1:1")
+		},
+	}
+	s := NewRedisStore(pool)
+
+	sub, err := s.Subscribe(context.Background(), "test-key:no-redis")
+	Ω(err).ShouldNot(BeNil())
+	Ω(sub).Should(BeNil())
+	Ω(pool.ActiveCount()).Should(Equal(0))
+}
@@ -0,0 +1,250 @@
+package once
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Store persists the job descriptors and notifies about their completion.
+//
+// The keys passed to a store are fully qualified, i.e. they already include
+// the client's namespace and key prefix.
+type Store interface {
+	// Create stores the given descriptor unless there is another descriptor
+	// under the same key that cannot be overridden (see
+	// JobDesc.CanBeOverridden), in which case the other descriptor is
+	// returned. If force is set, the descriptor is stored unconditionally.
+	// The descriptor expires in expire seconds. The subscribers are notified
+	// about the stored descriptor.
+	//
+	// If the other job succeeded recently and is throttled (see
+	// Options.ThrottleWindow), the other descriptor is returned as well,
+	// unless the given job is deferred to the end of the throttling window.
+	// In the latter case the stored descriptor is returned, having the same
+	// JID and RunAtMs set to the end of the window.
+	//
+	// Returns whether the stored descriptor replaced another one.
+	Create(
+		ctx context.Context,
+		key string,
+		desc *JobDesc,
+		expire int,
+		force bool,
+	) (other *JobDesc, replaced bool, err error)
+
+	// UpdateStatus sets the status (and the result, unless it is empty) of
+	// the descriptor if it still belongs to the given JID, and resets its
+	// expiration time. The subscribers are notified. StatusOK always sets
+	// the result, clearing the error left by a failed attempt.
+	//
+	// A job postponed to a later time (see Postpone) cannot start executing
+	// before that time. The status of a cancelled job is never changed:
+	// updating it to StatusCancelled only ends the running attempt and
+	// resets the expiration time, without notifying the subscribers.
+	//
+	// Starting executing the job adds an attempt run by the given worker
+	// to the descriptor (keeping the last MaxAttempts), the other statuses
+	// end the running attempt.
+	//
+	// Returns 0 in case of success, -1 if there is no descriptor, -2 if the
+	// descriptor belongs to another JID, -3 if the job cannot start yet and
+	// -4 if the job was cancelled.
+	UpdateStatus(
+		ctx context.Context,
+		key, jid, status string,
+		expire int,
+		updatedAt time.Time,
+		result, worker string,
+	) (int, error)
+
+	// Postpone moves the start time of the job waiting to start to the
+	// given time, unless it is already later, and resets the expiration
+	// time of the descriptor.
+	//
+	// Returns 0 in case of success, -1 if there is no descriptor, -2 if the
+	// descriptor belongs to another JID and -3 if the job is not waiting to
+	// start anymore.
+	Postpone(
+		ctx context.Context,
+		key, jid string,
+		runAt time.Time,
+		expire int,
+	) (int, error)
+
+	// Extend resets the expiration time of the descriptor of the executing
+	// job, keeping it from expiring while the job runs.
+	//
+	// Returns 0 in case of success, -1 if there is no descriptor, -2 if the
+	// descriptor belongs to another JID and -3 if the job is not executing.
+	Extend(ctx context.Context, key, jid string, expire int) (int, error)
+
+	// SetProgress sets the progress (and the progress message) of the
+	// executing job, keeping the expiration time of the descriptor. The
+	// subscribers are notified.
+	//
+	// Returns 0 in case of success, -1 if there is no descriptor, -2 if the
+	// descriptor belongs to another JID and -3 if the job is not executing.
+	SetProgress(
+		ctx context.Context,
+		key, jid string,
+		progress float64,
+		message string,
+	) (int, error)
+
+	// Cancel marks the job not done yet as cancelled and resets the
+	// expiration time of the descriptor. The subscribers are notified.
+	//
+	// Returns 0 in case of success, -1 if there is no descriptor, -2 if the
+	// descriptor belongs to another JID and -3 if the job is done already.
+	Cancel(
+		ctx context.Context,
+		key, jid string,
+		expire int,
+		cancelledAt time.Time,
+	) (int, error)
+
+	// Get returns the descriptor stored under the given key, or
+	// NoMatchingJobsErr.
+	Get(ctx context.Context, key string) (*JobDesc, error)
+
+	// Delete removes the descriptor if it still belongs to the given JID,
+	// whatever its status. The subscribers are not notified.
+	Delete(ctx context.Context, key, jid string) error
+
+	// Scan iterates over the descriptors stored under the keys starting
+	// with the given prefix, returning about count of them at a time along
+	// with the cursor of the next call. The iteration starts with the empty
+	// cursor and is complete once the empty cursor is returned. The
+	// descriptors stored or removed meanwhile might be missed, and the ones
+	// changed might be returned more than once.
+	Scan(
+		ctx context.Context,
+		prefix, cursor string,
+		count int,
+	) ([]*JobDesc, string, error)
+
+	// AcquireSlot takes one of the limit slots of the semaphore stored under
+	// the given key for the given JID, for lease seconds. If the JID already
+	// holds a slot, its lease is extended. Returns false if all the slots
+	// are taken.
+	AcquireSlot(
+		ctx context.Context,
+		key, jid string,
+		limit, lease int,
+	) (bool, error)
+
+	// ReleaseSlot frees the slot of the semaphore held by the given JID.
+	ReleaseSlot(ctx context.Context, key, jid string) error
+
+	// Subscribe starts listening to the changes of the jobs stored under
+	// the given keys: the new jobs stored by Create and the status
+	// transitions. The subscription is active once Subscribe returns.
+	Subscribe(ctx context.Context, keys ...string) (Subscription, error)
+}
+
+// Subscription delivers the notifications published by a Store.
+type Subscription interface {
+	// C returns the channel delivering the notifications. The channel is
+	// closed once the subscription is closed or failed; in the latter case
+	// the last notification carries the error.
+	C() <-chan Notification
+	Close() error
+}
+
+// The events published by a Store.
+const (
+	// EventCreated is published once a job descriptor is stored by Create,
+	// possibly replacing another job.
+	EventCreated = "created"
+	// EventStatus is published on every status transition of a job.
+	EventStatus = "status"
+	// EventProgress is published once the executing job reports its
+	// progress.
+	EventProgress = "progress"
+)
+
+type Notification struct {
+	Key string
+	// Event is one of the Event* constants; the published descriptors carry
+	// it in the "event" field.
+	Event string
+	Desc  *JobDesc
+	Err   error
+}
+
+// queuedSubscription queues the notifications, so a slow reader never
+// blocks the publisher.
+type queuedSubscription struct {
+	c chan Notification
+	// onClose is called once the subscription is closed.
+	onClose func()
+
+	mu    sync.Mutex
+	queue []Notification
+	wake  chan struct{}
+	done  chan struct{}
+	once  sync.Once
+}
+
+func newQueuedSubscription() *queuedSubscription {
+	sub := &queuedSubscription{
+		c:    make(chan Notification),
+		wake: make(chan struct{}, 1),
+		done: make(chan struct{}),
+	}
+	go sub.pump()
+
+	return sub
+}
+
+func (s *queuedSubscription) C() <-chan Notification {
+	return s.c
+}
+
+func (s *queuedSubscription) Close() error {
+	s.once.Do(func() {
+		if s.onClose != nil {
+			s.onClose()
+		}
+		close(s.done)
+	})
+
+	return nil
+}
+
+func (s *queuedSubscription) push(n Notification) {
+	s.mu.Lock()
+	s.queue = append(s.queue, n)
+	s.mu.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *queuedSubscription) pump() {
+	defer close(s.c)
+
+	for {
+		s.mu.Lock()
+		queue := s.queue
+		s.queue = nil
+		s.mu.Unlock()
+
+		for _, n := range queue {
+			select {
+			case s.c <- n:
+			case <-s.done:
+				return
+			}
+		}
+
+		select {
+		case <-s.wake:
+		case <-s.done:
+			return
+		}
+	}
+}
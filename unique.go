@@ -0,0 +1,39 @@
+package once
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+)
+
+// UniqueKeyFunc derives the deduplication key of a job from its args. Jobs
+// of the same type are considered identical if their keys are equal.
+type UniqueKeyFunc func(jobType string, args interface{}) (string, error)
+
+// HashArgs is the default UniqueKeyFunc. It returns a hash of the canonical
+// JSON representation of the args, so args encoding to the same JSON
+// objects produce the same key regardless of the order of the fields.
+func HashArgs(jobType string, args interface{}) (string, error) {
+	argsJson, err := json.Marshal(args)
+	if err != nil {
+		return "", err
+	}
+
+	// Re-encoding a generic value sorts the object keys; numbers are kept
+	// as is to avoid the float conversion.
+	var val interface{}
+	dec := json.NewDecoder(bytes.NewReader(argsJson))
+	dec.UseNumber()
+	if err := dec.Decode(&val); err != nil {
+		return "", err
+	}
+
+	canonicalJson, err := json.Marshal(val)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(canonicalJson)
+	return hex.EncodeToString(sum[:]), nil
+}
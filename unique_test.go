@@ -0,0 +1,43 @@
+package once
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestHashArgs(t *testing.T) {
+	RegisterTestingT(t)
+
+	h1, err := HashArgs("t", map[string]interface{}{"a": 1, "b": []int{1, 2}})
+	Ω(err).Should(BeNil())
+	Ω(h1).Should(HaveLen(64))
+
+	{
+		type args struct {
+			B []int `json:"b"`
+			A int   `json:"a"`
+		}
+
+		h, err := HashArgs("t", args{A: 1, B: []int{1, 2}})
+		Ω(err).Should(BeNil())
+		Ω(h).Should(Equal(h1))
+	}
+
+	{
+		h, err := HashArgs("t", map[string]interface{}{"a": 1, "b": []int{2, 1}})
+		Ω(err).Should(BeNil())
+		Ω(h).ShouldNot(Equal(h1))
+	}
+
+	{
+		h, err := HashArgs("t", map[string]interface{}{"a": "1", "b": []int{1, 2}})
+		Ω(err).Should(BeNil())
+		Ω(h).ShouldNot(Equal(h1))
+	}
+
+	{
+		_, err := HashArgs("t", func() {})
+		Ω(err).ShouldNot(BeNil())
+	}
+}
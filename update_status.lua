@@ -6,11 +6,18 @@
 --  [3] New expiration time for the job descriptor
 --  [4] New last update timestamp (in ms) for the job descriptor
 --  [5] Result value of the job, a success result value or an error
+--  [6] ID of the worker starting the job
+--  [7] Maximum number of the attempts kept in the descriptor
 --
 --  Return values:
 --    0  in case of success
 --   -1  if the key does not exist
 --   -2  if the JID is wrong
+--   -3  if the job cannot start yet since it was postponed
+--   -4  if the job was cancelled
+--
+-- Updating the cancelled job to "cancelled" ends its running attempt and
+-- resets the expiration time, the status is kept otherwise.
 
 local val = redis.call("GET", KEYS[1])
 
@@ -23,17 +30,67 @@ if val["jid"] ~= ARGV[1] then
   return -2
 end
 
+local cancelled = val["status"] == "cancelled"
+if cancelled and ARGV[2] ~= "cancelled" then
+  return -4
+end
+
+-- A postponed (debounced) job cannot start before its time
+if ARGV[2] == "executing" and val["status"] == "init-waiting" and
+    tonumber(val["run_at_ms"] or 0) > tonumber(ARGV[4]) then
+  return -3
+end
+
+-- Every attempt reports its own progress
+if ARGV[2] == "executing" then
+  val["progress"] = nil
+  val["progress_message"] = nil
+end
+
+-- Keep the history of the attempts
+local attempts = val["attempts"]
+if type(attempts) ~= "table" then
+  attempts = {}
+end
+if ARGV[2] == "executing" then
+  local attempt = {start_ms = tonumber(ARGV[4])}
+  if ARGV[6] ~= '' then
+    attempt["worker"] = ARGV[6]
+  end
+  table.insert(attempts, attempt)
+  while #attempts > tonumber(ARGV[7]) do
+    table.remove(attempts, 1)
+  end
+elseif ARGV[2] == "ok" or ARGV[2] == "failed" or
+    ARGV[2] == "retry-waiting" or ARGV[2] == "timed-out" or
+    ARGV[2] == "cancelled" then
+  local attempt = attempts[#attempts]
+  if attempt and attempt["end_ms"] == nil then
+    attempt["end_ms"] = tonumber(ARGV[4])
+    attempt["outcome"] = ARGV[2]
+    if ARGV[2] ~= "ok" and ARGV[5] ~= '' then
+      attempt["error"] = ARGV[5]
+    end
+  end
+end
+if #attempts > 0 then
+  val["attempts"] = attempts
+end
+
 val["status"] = ARGV[2]
 val["updated_ms"] = tonumber(ARGV[4])
 if ARGV[5] and ARGV[5] ~= '' then
   val["result"] = ARGV[5]
+elseif ARGV[2] == "ok" then
+  -- Drop the error of the failed attempt, it is kept in the attempts
+  val["result"] = nil
 end
 
-local valJson = cjson.encode(val)
-redis.call("SET", KEYS[1], valJson)
+redis.call("SET", KEYS[1], cjson.encode(val))
 redis.call("EXPIRE", KEYS[1], ARGV[3])
--- Notify the waiters if the job is done
-if val["status"] == "ok" or val["status"] == "failed" then
-	redis.call("PUBLISH", KEYS[1], valJson)
+-- Notify the waiters and the watchers, unless they know already
+if not cancelled then
+  val["event"] = "status"
+  redis.call("PUBLISH", KEYS[1], cjson.encode(val))
 end
 return 0
@@ -0,0 +1,183 @@
+package once
+
+import (
+	"context"
+	"time"
+)
+
+// WaitTarget identifies a job awaited by WaitAll or WaitAny.
+type WaitTarget struct {
+	Queue   string
+	JobType string
+	// Jid, if set, makes the target wait for the given job only, see
+	// WaitForJid.
+	Jid string
+	// Args, if not nil, identify the job enqueued with Options.UniqueByArgs.
+	Args interface{}
+}
+
+// WaitResult is the outcome of waiting for a WaitTarget.
+type WaitResult struct {
+	Desc *JobDesc
+	Err  error
+}
+
+// WaitAll waits for all the targets to complete, sharing the timeout among
+// them. The results are in the order of the targets; the targets not
+// completed in time get TimeoutErr. WaitOptions.Args is ignored.
+func WaitAll(targets []WaitTarget, options ...WaitOptions) []WaitResult {
+	return defaultClient().WaitAll(targets, options...)
+}
+
+// WaitAllContext is like WaitAll but stops waiting as soon as the given
+// context is done, in which case the targets not completed yet get
+// ctx.Err().
+func WaitAllContext(
+	ctx context.Context,
+	targets []WaitTarget,
+	options ...WaitOptions,
+) []WaitResult {
+	return defaultClient().WaitAllContext(ctx, targets, options...)
+}
+
+// WaitAny waits for any of the targets to complete. It returns the index of
+// the target and its result, or -1 and the error if none completed, e.g.
+// TimeoutErr. WaitOptions.Args is ignored.
+func WaitAny(targets []WaitTarget, options ...WaitOptions) (int, WaitResult) {
+	return defaultClient().WaitAny(targets, options...)
+}
+
+// WaitAnyContext is like WaitAny but stops waiting as soon as the given
+// context is done, in which case -1 and ctx.Err() are returned.
+func WaitAnyContext(
+	ctx context.Context,
+	targets []WaitTarget,
+	options ...WaitOptions,
+) (int, WaitResult) {
+	return defaultClient().WaitAnyContext(ctx, targets, options...)
+}
+
+// WaitAll is the client's counterpart of the package-level WaitAll.
+func (c *Client) WaitAll(
+	targets []WaitTarget,
+	options ...WaitOptions,
+) []WaitResult {
+	return c.WaitAllContext(context.Background(), targets, options...)
+}
+
+// WaitAllContext is the client's counterpart of the package-level
+// WaitAllContext.
+func (c *Client) WaitAllContext(
+	ctx context.Context,
+	targets []WaitTarget,
+	options ...WaitOptions,
+) []WaitResult {
+	results := make([]WaitResult, len(targets))
+	if len(targets) == 0 {
+		return results
+	}
+
+	tracker, err := c.newTargetsTracker(targets, options)
+	if err != nil {
+		for i := range results {
+			results[i].Err = err
+		}
+		return results
+	}
+
+	metrics := c.metrics()
+	start := time.Now()
+	resolved := make([]bool, len(targets))
+	err = tracker.track(ctx, func(i int, desc *JobDesc, err error) bool {
+		results[i] = WaitResult{Desc: desc, Err: err}
+		resolved[i] = true
+		metrics.JobAwaited(targets[i].Queue, targets[i].JobType,
+			time.Since(start), err)
+		return false
+	})
+	if err != nil {
+		for i := range results {
+			if !resolved[i] {
+				results[i].Err = err
+				metrics.JobAwaited(targets[i].Queue, targets[i].JobType,
+					time.Since(start), err)
+			}
+		}
+	}
+
+	return results
+}
+
+// WaitAny is the client's counterpart of the package-level WaitAny.
+func (c *Client) WaitAny(
+	targets []WaitTarget,
+	options ...WaitOptions,
+) (int, WaitResult) {
+	return c.WaitAnyContext(context.Background(), targets, options...)
+}
+
+// WaitAnyContext is the client's counterpart of the package-level
+// WaitAnyContext.
+func (c *Client) WaitAnyContext(
+	ctx context.Context,
+	targets []WaitTarget,
+	options ...WaitOptions,
+) (int, WaitResult) {
+	if len(targets) == 0 {
+		return -1, WaitResult{Err: NoMatchingJobsErr}
+	}
+
+	tracker, err := c.newTargetsTracker(targets, options)
+	if err != nil {
+		return -1, WaitResult{Err: err}
+	}
+
+	metrics := c.metrics()
+	start := time.Now()
+	index := -1
+	result := WaitResult{}
+	err = tracker.track(ctx, func(i int, desc *JobDesc, err error) bool {
+		index, result = i, WaitResult{Desc: desc, Err: err}
+		metrics.JobAwaited(targets[i].Queue, targets[i].JobType,
+			time.Since(start), err)
+		return true
+	})
+	if err != nil {
+		// None of the targets completed
+		for _, target := range targets {
+			metrics.JobAwaited(target.Queue, target.JobType,
+				time.Since(start), err)
+		}
+		return -1, WaitResult{Err: err}
+	}
+
+	return index, result
+}
+
+func (c *Client) newTargetsTracker(
+	targets []WaitTarget,
+	options []WaitOptions,
+) (*jobTracker, error) {
+	tracker := &jobTracker{
+		Store:   c.store(),
+		Options: mergeWaitOptions(options),
+	}
+
+	for _, target := range targets {
+		uniqueKey := ""
+		if target.Args != nil {
+			var err error
+			uniqueKey, err = c.uniqueKey(target.JobType, target.Args)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		tracker.Jobs = append(tracker.Jobs, &trackedJob{
+			Key: c.key(target.Queue, target.JobType, uniqueKey),
+			Jid: target.Jid,
+		})
+	}
+
+	return tracker, nil
+}
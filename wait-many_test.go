@@ -0,0 +1,187 @@
+package once
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/PlanitarInc/go-workers"
+	"github.com/gomodule/redigo/redis"
+	. "github.com/onsi/gomega"
+)
+
+func TestWaitAll(t *testing.T) {
+	RegisterTestingT(t)
+
+	setupRedis()
+	defer cleanRedis()
+
+	conn := workers.Config.Pool.Get()
+	defer conn.Close()
+
+	prefix := workers.Config.Namespace + "once:q:wait-all:"
+	for jobType, val := range map[string]string{
+		"ok":        `{"jid":"1","status":"ok"}`,
+		"executing": `{"jid":"2","status":"executing"}`,
+		"failed":    `{"jid":"3","status":"failed","result":"boom"}`,
+		"other":     `{"jid":"5","status":"executing"}`,
+	} {
+		res, err := redis.String(conn.Do("SET", prefix+jobType, val))
+		Ω(err).Should(BeNil())
+		Ω(res).Should(Equal("OK"))
+	}
+
+	updated := make(chan struct{})
+	go func() {
+		defer close(updated)
+		time.Sleep(20 * time.Millisecond)
+		n, err := updateJobStatus(conn, prefix+"executing", "2", StatusOK, 10)
+		Ω(err).Should(BeNil())
+		Ω(n).Should(Equal(0))
+	}()
+
+	results := WaitAll([]WaitTarget{
+		{Queue: "wait-all", JobType: "ok"},
+		{Queue: "wait-all", JobType: "executing"},
+		{Queue: "wait-all", JobType: "failed"},
+		{Queue: "wait-all", JobType: "other", Jid: "4"},
+	}, WaitOptions{Timeout: time.Second})
+	<-updated
+
+	Ω(results).Should(HaveLen(4))
+
+	Ω(results[0].Err).Should(BeNil())
+	Ω(results[0].Desc).Should(Equal(&JobDesc{Jid: "1", Status: StatusOK}))
+
+	Ω(results[1].Err).Should(BeNil())
+	Ω(results[1].Desc.Jid).Should(Equal("2"))
+	Ω(results[1].Desc.Status).Should(Equal(StatusOK))
+
+	Ω(results[2].Err).Should(BeNil())
+	Ω(results[2].Desc).Should(Equal(&JobDesc{
+		Jid:    "3",
+		Status: StatusFailed,
+		Result: "boom",
+	}))
+
+	Ω(results[3].Err).Should(Equal(SupersededErr))
+	Ω(results[3].Desc).Should(BeNil())
+}
+
+func TestWaitAll_Timeout(t *testing.T) {
+	RegisterTestingT(t)
+
+	setupRedis()
+	defer cleanRedis()
+
+	conn := workers.Config.Pool.Get()
+	defer conn.Close()
+
+	prefix := workers.Config.Namespace + "once:q:wait-all-timeout:"
+	for jobType, val := range map[string]string{
+		"ok":        `{"jid":"1","status":"ok"}`,
+		"executing": `{"jid":"2","status":"executing"}`,
+	} {
+		res, err := redis.String(conn.Do("SET", prefix+jobType, val))
+		Ω(err).Should(BeNil())
+		Ω(res).Should(Equal("OK"))
+	}
+
+	start := time.Now()
+	results := WaitAll([]WaitTarget{
+		{Queue: "wait-all-timeout", JobType: "ok"},
+		{Queue: "wait-all-timeout", JobType: "executing"},
+		{Queue: "wait-all-timeout", JobType: "missing"},
+	}, WaitOptions{Timeout: 50 * time.Millisecond})
+	// The timeout is shared
+	Ω(time.Since(start)).Should(BeNumerically("<", 100*time.Millisecond))
+
+	Ω(results).Should(Equal([]WaitResult{
+		{Desc: &JobDesc{Jid: "1", Status: StatusOK}},
+		{Err: TimeoutErr},
+		{Err: TimeoutErr},
+	}))
+}
+
+func TestWaitAny(t *testing.T) {
+	RegisterTestingT(t)
+
+	setupRedis()
+	defer cleanRedis()
+
+	conn := workers.Config.Pool.Get()
+	defer conn.Close()
+
+	prefix := workers.Config.Namespace + "once:q:wait-any:"
+	for jobType, val := range map[string]string{
+		"first":  `{"jid":"1","status":"executing"}`,
+		"second": `{"jid":"2","status":"executing"}`,
+	} {
+		res, err := redis.String(conn.Do("SET", prefix+jobType, val))
+		Ω(err).Should(BeNil())
+		Ω(res).Should(Equal("OK"))
+	}
+
+	targets := []WaitTarget{
+		{Queue: "wait-any", JobType: "first"},
+		{Queue: "wait-any", JobType: "second"},
+	}
+
+	{
+		i, res := WaitAny(targets, WaitOptions{Timeout: 50 * time.Millisecond})
+		Ω(i).Should(Equal(-1))
+		Ω(res).Should(Equal(WaitResult{Err: TimeoutErr}))
+	}
+
+	updated := make(chan struct{})
+	go func() {
+		defer close(updated)
+		time.Sleep(20 * time.Millisecond)
+		n, err := updateJobStatus(conn, prefix+"second", "2", StatusFailed, 10)
+		Ω(err).Should(BeNil())
+		Ω(n).Should(Equal(0))
+	}()
+
+	i, res := WaitAny(targets, WaitOptions{Timeout: time.Second})
+	<-updated
+	Ω(i).Should(Equal(1))
+	Ω(res.Err).Should(BeNil())
+	Ω(res.Desc.Jid).Should(Equal("2"))
+	Ω(res.Desc.Status).Should(Equal(StatusFailed))
+}
+
+func TestWaitAny_Args(t *testing.T) {
+	RegisterTestingT(t)
+
+	client := &Client{
+		Store: NewMemoryStore(),
+		EnqueueMsg: func(ctx context.Context, msg *workers.Msg) error {
+			return nil
+		},
+	}
+	opts := &Options{UniqueByArgs: true}
+
+	jid1, err := client.Enqueue("wait-any-args", "add", []int{1}, opts)
+	Ω(err).Should(BeNil())
+	jid2, err := client.Enqueue("wait-any-args", "add", []int{2}, opts)
+	Ω(err).Should(BeNil())
+
+	{
+		uniqueKey, err := client.uniqueKey("add", []int{2})
+		Ω(err).Should(BeNil())
+
+		key := client.key("wait-any-args", "add", uniqueKey)
+		n, err := client.Store.UpdateStatus(context.Background(), key, jid2,
+			StatusOK, 10, time.Now(), "", "")
+		Ω(err).Should(BeNil())
+		Ω(n).Should(Equal(0))
+	}
+
+	i, res := client.WaitAny([]WaitTarget{
+		{Queue: "wait-any-args", JobType: "add", Args: []int{1}, Jid: jid1},
+		{Queue: "wait-any-args", JobType: "add", Args: []int{2}},
+	}, WaitOptions{Timeout: time.Second})
+	Ω(i).Should(Equal(1))
+	Ω(res.Err).Should(BeNil())
+	Ω(res.Desc.Jid).Should(Equal(jid2))
+}
@@ -1,12 +1,11 @@
 package once
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
-	"sync"
 	"time"
 
-	"github.com/PlanitarInc/go-workers"
 	"github.com/gomodule/redigo/redis"
 )
 
@@ -14,41 +13,187 @@ var (
 	NoMatchingJobsErr = errors.New("no matching jobs found")
 	AbortedErr        = errors.New("aborted")
 	TimeoutErr        = errors.New("timeout")
+	// SupersededErr is returned by WaitForJid if another job replaced the
+	// awaited one.
+	SupersededErr = errors.New("superseded by another job")
+	// ExpiredErr is returned if the descriptor of the awaited job is gone
+	// before the job completed, e.g. the worker running it crashed.
+	ExpiredErr = errors.New("job descriptor expired")
 )
 
 type WaitOptions struct {
 	StopIfEmpty bool
 	Timeout     time.Duration
+	// CheckInterval is how often the descriptor is re-read while waiting,
+	// to notice it expired (nothing is published then). Defaults to 10
+	// seconds.
+	CheckInterval time.Duration
+	// Args, if not nil, identify the job enqueued with Options.UniqueByArgs.
+	Args interface{}
 }
 
 func WaitForJobType(queue, jobType string, options ...WaitOptions) (*JobDesc, error) {
-	opts := WaitOptions{}
-	if len(options) > 0 {
-		opts = options[0]
-	}
+	return defaultClient().WaitForJobType(queue, jobType, options...)
+}
 
-	if opts.Timeout == 0 {
-		opts.Timeout = time.Hour
-	}
+// WaitForJobTypeContext is like WaitForJobType but stops waiting as soon as
+// the given context is done, in which case ctx.Err() is returned.
+func WaitForJobTypeContext(
+	ctx context.Context,
+	queue, jobType string,
+	options ...WaitOptions,
+) (*JobDesc, error) {
+	return defaultClient().WaitForJobTypeContext(ctx, queue, jobType, options...)
+}
+
+// WaitForJid waits for the job with the given JID to complete. Unlike
+// WaitForJobType, it never returns the outcome of another job of the same
+// type: SupersededErr is returned if the job was replaced by another one,
+// and ExpiredErr if its descriptor is gone. WaitOptions.StopIfEmpty is
+// ignored.
+func WaitForJid(
+	queue, jobType, jid string,
+	options ...WaitOptions,
+) (*JobDesc, error) {
+	return defaultClient().WaitForJid(queue, jobType, jid, options...)
+}
+
+// WaitForJidContext is like WaitForJid but stops waiting as soon as the
+// given context is done, in which case ctx.Err() is returned.
+func WaitForJidContext(
+	ctx context.Context,
+	queue, jobType, jid string,
+	options ...WaitOptions,
+) (*JobDesc, error) {
+	return defaultClient().WaitForJidContext(ctx, queue, jobType, jid,
+		options...)
+}
+
+// GetDesc returns the descriptor of the job of the given type. The args, if
+// given, identify the job enqueued with Options.UniqueByArgs.
+func GetDesc(queue, jobType string, args ...interface{}) (*JobDesc, error) {
+	return defaultClient().GetDesc(queue, jobType, args...)
+}
+
+// GetDescContext is like GetDesc but uses the given context for obtaining
+// a Redis connection.
+func GetDescContext(
+	ctx context.Context,
+	queue, jobType string,
+	args ...interface{},
+) (*JobDesc, error) {
+	return defaultClient().GetDescContext(ctx, queue, jobType, args...)
+}
+
+// WaitForJobType is the client's counterpart of the package-level
+// WaitForJobType.
+func (c *Client) WaitForJobType(
+	queue, jobType string,
+	options ...WaitOptions,
+) (*JobDesc, error) {
+	return c.WaitForJobTypeContext(context.Background(),
+		queue, jobType, options...)
+}
+
+// WaitForJobTypeContext is the client's counterpart of the package-level
+// WaitForJobTypeContext.
+func (c *Client) WaitForJobTypeContext(
+	ctx context.Context,
+	queue, jobType string,
+	options ...WaitOptions,
+) (*JobDesc, error) {
+	return c.wait(ctx, queue, jobType, "", options...)
+}
 
-	key := workers.Config.Namespace + "once:q:" + queue + ":" + jobType
+// WaitForJid is the client's counterpart of the package-level WaitForJid.
+func (c *Client) WaitForJid(
+	queue, jobType, jid string,
+	options ...WaitOptions,
+) (*JobDesc, error) {
+	return c.WaitForJidContext(context.Background(),
+		queue, jobType, jid, options...)
+}
+
+// WaitForJidContext is the client's counterpart of the package-level
+// WaitForJidContext.
+func (c *Client) WaitForJidContext(
+	ctx context.Context,
+	queue, jobType, jid string,
+	options ...WaitOptions,
+) (*JobDesc, error) {
+	return c.wait(ctx, queue, jobType, jid, options...)
+}
+
+func (c *Client) wait(
+	ctx context.Context,
+	queue, jobType, jid string,
+	options ...WaitOptions,
+) (*JobDesc, error) {
+	opts := mergeWaitOptions(options)
+
+	uniqueKey := ""
+	if opts.Args != nil {
+		var err error
+		if uniqueKey, err = c.uniqueKey(jobType, opts.Args); err != nil {
+			return nil, err
+		}
+	}
 
 	tracker := jobTracker{
-		Key:     key,
+		Store: c.store(),
+		Jobs: []*trackedJob{{
+			Key: c.key(queue, jobType, uniqueKey),
+			Jid: jid,
+		}},
 		Options: opts,
 	}
-	desc, err := tracker.Wait()
+	start := time.Now()
+	desc, err := tracker.Wait(ctx)
+	c.metrics().JobAwaited(queue, jobType, time.Since(start), err)
 
 	return desc, err
 }
 
-func GetDesc(queue, jobType string) (*JobDesc, error) {
-	conn := workers.Config.Pool.Get()
-	defer conn.Close()
+// GetDesc is the client's counterpart of the package-level GetDesc.
+func (c *Client) GetDesc(
+	queue, jobType string,
+	args ...interface{},
+) (*JobDesc, error) {
+	return c.GetDescContext(context.Background(), queue, jobType, args...)
+}
+
+// GetDescContext is the client's counterpart of the package-level
+// GetDescContext.
+func (c *Client) GetDescContext(
+	ctx context.Context,
+	queue, jobType string,
+	args ...interface{},
+) (*JobDesc, error) {
+	uniqueKey := ""
+	if len(args) > 0 {
+		var err error
+		if uniqueKey, err = c.uniqueKey(jobType, args[0]); err != nil {
+			return nil, err
+		}
+	}
+
+	return c.store().Get(ctx, c.key(queue, jobType, uniqueKey))
+}
+
+func mergeWaitOptions(options []WaitOptions) WaitOptions {
+	opts := WaitOptions{}
+	if len(options) > 0 {
+		opts = options[0]
+	}
 
-	key := workers.Config.Namespace + "once:q:" + queue + ":" + jobType
+	if opts.Timeout == 0 {
+		opts.Timeout = time.Hour
+	}
+	if opts.CheckInterval == 0 {
+		opts.CheckInterval = 10 * time.Second
+	}
 
-	return getDescriptor(conn, key)
+	return opts
 }
 
 func getDescriptor(conn redis.Conn, key string) (*JobDesc, error) {
@@ -71,146 +216,177 @@ func getDescriptor(conn redis.Conn, key string) (*JobDesc, error) {
 	return &desc, nil
 }
 
-type jobTracker struct {
-	Conn       redis.Conn
-	PubSubConn *redis.PubSubConn
-	Key        string
-	Options    WaitOptions
+// trackedJob is a job awaited by a jobTracker.
+type trackedJob struct {
+	Key string
+	// Jid, if set, is the only job awaited under the key.
+	Jid string
 
-	aborted chan struct{}
+	// seen is set once the descriptor of the awaited job was found.
+	seen bool
 }
 
-type asyncResut struct {
-	JobDesc *JobDesc
-	Error   error
-}
-
-func (t *jobTracker) Wait() (*JobDesc, error) {
-	t.Conn = workers.Config.Pool.Get()
-	defer t.Conn.Close()
+// jobTracker waits for the completion of the jobs using a single
+// subscription covering all of them.
+type jobTracker struct {
+	Store   Store
+	Jobs    []*trackedJob
+	Options WaitOptions
 
-	pubsubconn := workers.Config.Pool.Get()
-	t.PubSubConn = &redis.PubSubConn{pubsubconn}
-	defer pubsubconn.Close()
+	aborted chan struct{}
+}
 
-	// 2 is more than enough
-	result := make(chan *asyncResut, 2)
-	defer close(result)
+// Wait waits for a single job.
+func (t *jobTracker) Wait(ctx context.Context) (*JobDesc, error) {
+	var desc *JobDesc
+	var err error
 
-	wg := sync.WaitGroup{}
-	desc, err := t.waitForCompletion(&wg, result)
-	wg.Wait()
+	trackErr := t.track(ctx, func(i int, d *JobDesc, e error) bool {
+		desc, err = d, e
+		return true
+	})
+	if trackErr != nil {
+		return nil, trackErr
+	}
 
 	return desc, err
 }
 
-func (t *jobTracker) waitForCompletion(
-	wg *sync.WaitGroup,
-	result chan *asyncResut,
-) (*JobDesc, error) {
-	var desc *JobDesc
-	var err error
-
+// track waits until all the jobs are resolved, i.e. done or known to be
+// never done, or until the done callback returns true. The callback is
+// called once the job at the given index is resolved. An error is returned
+// if the waiting stopped before that, e.g. because of the timeout.
+func (t *jobTracker) track(
+	ctx context.Context,
+	done func(i int, desc *JobDesc, err error) bool,
+) error {
 	t.aborted = make(chan struct{})
 	defer close(t.aborted)
 
-	// The channel is used to sync the subscribe and getOnce goroutines.
-	subscribed := make(chan struct{})
-	defer close(subscribed)
-
-	go t.subscribeWait(wg, result, subscribed)
-	defer t.unsubscribeWait()
-
-	desc, err = t.getIfDone(subscribed)
-	if desc != nil || err != nil {
-		return desc, err
+	keys := []string{}
+	indexes := map[string][]int{}
+	pending := map[int]bool{}
+	for i, job := range t.Jobs {
+		keys = append(keys, job.Key)
+		indexes[job.Key] = append(indexes[job.Key], i)
+		pending[i] = true
 	}
 
-	select {
-	case res := <-result:
-		desc = res.JobDesc
-		err = res.Error
+	sub, err := t.Store.Subscribe(ctx, keys...)
+	if err != nil {
+		return err
+	}
+	defer sub.Close()
 
-	case <-t.aborted:
-		err = AbortedErr
+	// resolve reports the job if it is resolved, returns true if the
+	// tracking should stop.
+	resolve := func(i int, desc *JobDesc, err error) bool {
+		if desc == nil && err == nil {
+			return false
+		}
 
-	case <-time.After(t.Options.Timeout):
-		err = TimeoutErr
+		delete(pending, i)
+		return done(i, desc, err) || len(pending) == 0
 	}
 
-	return desc, err
-}
+	checkAll := func() bool {
+		for i := range t.Jobs {
+			if !pending[i] {
+				continue
+			}
+			if desc, err := t.getIfDone(ctx, i); resolve(i, desc, err) {
+				return true
+			}
+		}
+		return false
+	}
 
-func (t jobTracker) Stop() {
-	t.aborted <- struct{}{}
-}
+	if checkAll() {
+		return nil
+	}
 
-func (t jobTracker) subscribeWait(
-	wg *sync.WaitGroup,
-	result chan<- *asyncResut,
-	subscribed chan<- struct{},
-) {
-	wg.Add(1)
-	defer wg.Done()
+	timeout := time.NewTimer(t.Options.Timeout)
+	defer timeout.Stop()
 
-	if err := t.PubSubConn.Subscribe(t.Key); err != nil {
-		result <- &asyncResut{nil, err}
-		subscribed <- struct{}{}
-		return
-	}
+	recheck := time.NewTicker(t.Options.CheckInterval)
+	defer recheck.Stop()
 
 	for {
-		switch v := t.PubSubConn.Receive().(type) {
-		case redis.Message:
-
-			desc := JobDesc{}
-			err := json.Unmarshal(v.Data, &desc)
-			if err != nil {
-				result <- &asyncResut{nil, err}
-				return
+		select {
+		case n, ok := <-sub.C():
+			if !ok {
+				return AbortedErr
 			}
-
-			// XXX should always be done at this point
-			if desc.IsDone() {
-				result <- &asyncResut{&desc, err}
-				return
+			if _, ok := indexes[n.Key]; !ok && n.Err != nil {
+				return n.Err
+			}
+			for _, i := range indexes[n.Key] {
+				if !pending[i] {
+					continue
+				}
+				if desc, err := t.check(i, n.Desc, n.Err); resolve(i, desc, err) {
+					return nil
+				}
 			}
 
-		case redis.Subscription:
-			if v.Kind == "subscribe" && v.Count == 1 {
-				subscribed <- struct{}{}
-			} else if v.Count == 0 {
-				return
+		case <-recheck.C:
+			if checkAll() {
+				return nil
 			}
 
-		case error:
-			result <- &asyncResut{nil, v}
-			return
+		case <-t.aborted:
+			return AbortedErr
+
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case <-timeout.C:
+			return TimeoutErr
 		}
 	}
 }
 
-func (t jobTracker) unsubscribeWait() {
-	t.PubSubConn.Unsubscribe(t.Key)
+func (t jobTracker) Stop() {
+	t.aborted <- struct{}{}
+}
+
+func (t *jobTracker) getIfDone(
+	ctx context.Context,
+	i int,
+) (*JobDesc, error) {
+	desc, err := t.Store.Get(ctx, t.Jobs[i].Key)
+	return t.check(i, desc, err)
 }
 
-func (t jobTracker) getIfDone(subscribed <-chan struct{}) (*JobDesc, error) {
-	// Wait until we're subscribed to the job updates
-	_, ok := <-subscribed
-	if !ok {
+// check returns the descriptor if the awaited job is done, or an error if
+// the waiting for it should stop. Returns nils if the job is still in
+// progress or not enqueued yet.
+func (t *jobTracker) check(
+	i int,
+	desc *JobDesc,
+	err error,
+) (*JobDesc, error) {
+	job := t.Jobs[i]
+
+	if err == NoMatchingJobsErr {
+		if job.Jid != "" || job.seen {
+			return nil, ExpiredErr
+		}
+		if t.Options.StopIfEmpty {
+			return nil, NoMatchingJobsErr
+		}
 		return nil, nil
 	}
-
-	desc, err := getDescriptor(t.Conn, t.Key)
-	if err != nil && err != NoMatchingJobsErr {
+	if err != nil {
 		return nil, err
 	}
 
-	if err == NoMatchingJobsErr && t.Options.StopIfEmpty {
-		return nil, NoMatchingJobsErr
+	if job.Jid != "" && desc.Jid != job.Jid {
+		return nil, SupersededErr
 	}
+	job.seen = true
 
-	if desc != nil && desc.IsDone() {
+	if desc.IsDone() {
 		return desc, nil
 	}
 
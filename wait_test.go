@@ -1,6 +1,7 @@
 package once
 
 import (
+	"context"
 	"net"
 	"testing"
 	"time"
@@ -433,3 +434,251 @@ func TestWaitForJobType_FalsePublish(t *testing.T) {
 	<-doneWaitingC
 	Ω(doneWaitingC).Should(BeClosed())
 }
+
+func TestWaitForJobTypeContext_Cancelled(t *testing.T) {
+	RegisterTestingT(t)
+
+	setupRedis()
+	defer cleanRedis()
+
+	queue := "wait-10"
+	jobType := "email"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+
+	desc, err := WaitForJobTypeContext(ctx, queue, jobType)
+	Ω(err).Should(Equal(context.Canceled))
+	Ω(desc).Should(BeNil())
+}
+
+func TestWaitForJobTypeContext_Deadline(t *testing.T) {
+	RegisterTestingT(t)
+
+	setupRedis()
+	defer cleanRedis()
+
+	queue := "wait-11"
+	jobType := "email"
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+
+	desc, err := WaitForJobTypeContext(ctx, queue, jobType, WaitOptions{
+		Timeout: time.Second,
+	})
+	Ω(err).Should(Equal(context.DeadlineExceeded))
+	Ω(desc).Should(BeNil())
+}
+
+func TestWaitForJid(t *testing.T) {
+	RegisterTestingT(t)
+
+	setupRedis()
+	defer cleanRedis()
+
+	conn := workers.Config.Pool.Get()
+	defer conn.Close()
+
+	queue := "wait-12"
+	jobType := "email"
+	key := workers.Config.Namespace + "once:q:" + queue + ":" + jobType
+
+	{
+		// The descriptor is gone
+		desc, err := WaitForJid(queue, jobType, "1")
+		Ω(err).Should(Equal(ExpiredErr))
+		Ω(desc).Should(BeNil())
+	}
+
+	{
+		res, err := redis.String(conn.Do("SET", key, `{"jid":"1","status":"ok"}`))
+		Ω(err).Should(BeNil())
+		Ω(res).Should(Equal("OK"))
+	}
+
+	{
+		desc, err := WaitForJid(queue, jobType, "1")
+		Ω(err).Should(BeNil())
+		Ω(desc).Should(Equal(&JobDesc{Jid: "1", Status: StatusOK}))
+	}
+
+	{
+		// Another job took over
+		desc, err := WaitForJid(queue, jobType, "0")
+		Ω(err).Should(Equal(SupersededErr))
+		Ω(desc).Should(BeNil())
+	}
+}
+
+func TestWaitForJid_WaitUntilDone(t *testing.T) {
+	RegisterTestingT(t)
+
+	setupRedis()
+	defer cleanRedis()
+
+	conn := workers.Config.Pool.Get()
+	defer conn.Close()
+
+	queue := "wait-13"
+	jobType := "email"
+	key := workers.Config.Namespace + "once:q:" + queue + ":" + jobType
+
+	{
+		res, err := redis.String(conn.Do("SET", key,
+			`{"jid":"1","status":"executing"}`))
+		Ω(err).Should(BeNil())
+		Ω(res).Should(Equal("OK"))
+	}
+
+	updated := make(chan struct{})
+	go func() {
+		defer close(updated)
+		time.Sleep(20 * time.Millisecond)
+		n, err := updateJobStatus(conn, key, "1", StatusOK, 10)
+		Ω(err).Should(BeNil())
+		Ω(n).Should(Equal(0))
+	}()
+
+	desc, err := WaitForJid(queue, jobType, "1", WaitOptions{
+		Timeout: time.Second,
+	})
+	<-updated
+	Ω(err).Should(BeNil())
+	Ω(desc.Jid).Should(Equal("1"))
+	Ω(desc.Status).Should(Equal(StatusOK))
+}
+
+func TestWaitForJid_Superseded(t *testing.T) {
+	RegisterTestingT(t)
+
+	setupRedis()
+	defer cleanRedis()
+
+	queue := "wait-14"
+	jobType := "email"
+
+	jid, err := Enqueue(queue, jobType, nil, nil)
+	Ω(err).Should(BeNil())
+
+	enqueued := make(chan struct{})
+	go func() {
+		defer close(enqueued)
+		time.Sleep(20 * time.Millisecond)
+		_, err := EnqueueForce(queue, jobType, nil, nil)
+		Ω(err).Should(BeNil())
+	}()
+
+	desc, err := WaitForJid(queue, jobType, jid, WaitOptions{
+		Timeout: time.Second,
+	})
+	<-enqueued
+	Ω(err).Should(Equal(SupersededErr))
+	Ω(desc).Should(BeNil())
+}
+
+func TestWaitForJid_MemoryStore(t *testing.T) {
+	RegisterTestingT(t)
+
+	client := &Client{
+		Store: NewMemoryStore(),
+		EnqueueMsg: func(ctx context.Context, msg *workers.Msg) error {
+			return nil
+		},
+	}
+
+	jid, err := client.Enqueue("wait-15", "email", nil, nil)
+	Ω(err).Should(BeNil())
+
+	enqueued := make(chan struct{})
+	go func() {
+		defer close(enqueued)
+		time.Sleep(20 * time.Millisecond)
+		_, err := client.EnqueueForce("wait-15", "email", nil, nil)
+		Ω(err).Should(BeNil())
+	}()
+
+	desc, err := client.WaitForJid("wait-15", "email", jid, WaitOptions{
+		Timeout: time.Second,
+	})
+	<-enqueued
+	Ω(err).Should(Equal(SupersededErr))
+	Ω(desc).Should(BeNil())
+}
+
+func TestWaitForJobType_Expired(t *testing.T) {
+	RegisterTestingT(t)
+
+	setupRedis()
+	defer cleanRedis()
+
+	conn := workers.Config.Pool.Get()
+	defer conn.Close()
+
+	queue := "wait-16"
+	jobType := "email"
+	key := workers.Config.Namespace + "once:q:" + queue + ":" + jobType
+
+	{
+		// The worker running the job is gone
+		res, err := redis.String(conn.Do("SET", key,
+			`{"jid":"1","status":"executing"}`, "PX", 200))
+		Ω(err).Should(BeNil())
+		Ω(res).Should(Equal("OK"))
+	}
+
+	start := time.Now()
+	desc, err := WaitForJobType(queue, jobType, WaitOptions{
+		Timeout:       5 * time.Second,
+		CheckInterval: 50 * time.Millisecond,
+	})
+	Ω(err).Should(Equal(ExpiredErr))
+	Ω(desc).Should(BeNil())
+	Ω(time.Since(start)).Should(BeNumerically("<", time.Second))
+}
+
+func TestWaitForJobType_NotEnqueuedYet(t *testing.T) {
+	RegisterTestingT(t)
+
+	setupRedis()
+	defer cleanRedis()
+
+	queue := "wait-17"
+	jobType := "email"
+
+	// A job type never seen is not considered expired
+	desc, err := WaitForJobType(queue, jobType, WaitOptions{
+		Timeout:       200 * time.Millisecond,
+		CheckInterval: 50 * time.Millisecond,
+	})
+	Ω(err).Should(Equal(TimeoutErr))
+	Ω(desc).Should(BeNil())
+}
+
+func TestWaitForJid_Expired(t *testing.T) {
+	RegisterTestingT(t)
+
+	client := &Client{
+		Store: NewMemoryStore(),
+		EnqueueMsg: func(ctx context.Context, msg *workers.Msg) error {
+			return nil
+		},
+	}
+
+	jid, err := client.Enqueue("wait-18", "email", nil, &Options{
+		InitWaitTime: 1,
+	})
+	Ω(err).Should(BeNil())
+
+	start := time.Now()
+	desc, err := client.WaitForJid("wait-18", "email", jid, WaitOptions{
+		Timeout:       5 * time.Second,
+		CheckInterval: 100 * time.Millisecond,
+	})
+	Ω(err).Should(Equal(ExpiredErr))
+	Ω(desc).Should(BeNil())
+	Ω(time.Since(start)).Should(BeNumerically("<", 2*time.Second))
+}
@@ -0,0 +1,70 @@
+package once
+
+import (
+	"context"
+)
+
+// Watch streams the changes of the job of the given type: the new jobs
+// enqueued (including the ones replacing the current job), every status
+// transition and the progress reported, in order. The event of each
+// notification tells them apart, see EventCreated, EventStatus and
+// EventProgress. The args, if given, identify the job enqueued with
+// Options.UniqueByArgs. The channel is closed once the context is done.
+func Watch(
+	ctx context.Context,
+	queue, jobType string,
+	args ...interface{},
+) (<-chan Notification, error) {
+	return defaultClient().Watch(ctx, queue, jobType, args...)
+}
+
+// Watch is the client's counterpart of the package-level Watch.
+func (c *Client) Watch(
+	ctx context.Context,
+	queue, jobType string,
+	args ...interface{},
+) (<-chan Notification, error) {
+	uniqueKey := ""
+	if len(args) > 0 {
+		var err error
+		if uniqueKey, err = c.uniqueKey(jobType, args[0]); err != nil {
+			return nil, err
+		}
+	}
+
+	sub, err := c.store().Subscribe(ctx, c.key(queue, jobType, uniqueKey))
+	if err != nil {
+		return nil, err
+	}
+
+	notifications := make(chan Notification)
+
+	go func() {
+		defer close(notifications)
+		defer sub.Close()
+
+		for {
+			select {
+			case n, ok := <-sub.C():
+				if !ok {
+					return
+				}
+				// Skip the payloads that could not be decoded
+				if n.Err != nil || n.Desc == nil {
+					continue
+				}
+
+				select {
+				case notifications <- n:
+				case <-ctx.Done():
+					return
+				}
+
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return notifications, nil
+}
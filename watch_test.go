@@ -0,0 +1,115 @@
+package once
+
+import (
+	"context"
+	"testing"
+
+	"github.com/PlanitarInc/go-workers"
+	. "github.com/onsi/gomega"
+)
+
+func TestWatch(t *testing.T) {
+	RegisterTestingT(t)
+
+	setupRedis()
+	defer cleanRedis()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	queue := "watch-1"
+	jobType := "email"
+
+	descs, err := Watch(ctx, queue, jobType)
+	Ω(err).Should(BeNil())
+
+	jid, err := Enqueue(queue, jobType, nil, nil)
+	Ω(err).Should(BeNil())
+
+	{
+		var n Notification
+		Eventually(descs).Should(Receive(&n))
+		desc := n.Desc
+		Ω(desc.Jid).Should(Equal(jid))
+		Ω(desc.Status).Should(Equal(StatusInitWaiting))
+		Ω(n.Event).Should(Equal(EventCreated))
+	}
+
+	msg, _ := workers.NewMsg(`{
+		"jid": "` + jid + `",
+		"queue": "watch-1",
+		"x-once": {"job_type": "email"}
+	}`)
+	m := Middleware{}
+	ack := m.Call(queue, msg, func() bool {
+		var n Notification
+		Eventually(descs).Should(Receive(&n))
+		desc := n.Desc
+		Ω(desc.Jid).Should(Equal(jid))
+		Ω(desc.Status).Should(Equal(StatusExecuting))
+		Ω(n.Event).Should(Equal(EventStatus))
+		return true
+	})
+	Ω(ack).Should(BeTrue())
+
+	{
+		var n Notification
+		Eventually(descs).Should(Receive(&n))
+		desc := n.Desc
+		Ω(desc.Jid).Should(Equal(jid))
+		Ω(desc.Status).Should(Equal(StatusOK))
+		Ω(n.Event).Should(Equal(EventStatus))
+	}
+
+	// A job replacing the current one
+	newJid, err := EnqueueForce(queue, jobType, nil, nil)
+	Ω(err).Should(BeNil())
+
+	{
+		var n Notification
+		Eventually(descs).Should(Receive(&n))
+		desc := n.Desc
+		Ω(desc.Jid).Should(Equal(newJid))
+		Ω(desc.Status).Should(Equal(StatusInitWaiting))
+		Ω(n.Event).Should(Equal(EventCreated))
+
+		// Nothing is published on delete
+		Ω(Delete(ctx, desc)).Should(BeNil())
+		Consistently(descs).ShouldNot(Receive())
+	}
+
+	cancel()
+	Eventually(descs).Should(BeClosed())
+}
+
+func TestWatch_Args(t *testing.T) {
+	RegisterTestingT(t)
+
+	client := &Client{
+		Store: NewMemoryStore(),
+		EnqueueMsg: func(ctx context.Context, msg *workers.Msg) error {
+			return nil
+		},
+	}
+	opts := &Options{UniqueByArgs: true}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	descs, err := client.Watch(ctx, "watch-2", "add", []int{1})
+	Ω(err).Should(BeNil())
+
+	_, err = client.Enqueue("watch-2", "add", []int{2}, opts)
+	Ω(err).Should(BeNil())
+	jid, err := client.Enqueue("watch-2", "add", []int{1}, opts)
+	Ω(err).Should(BeNil())
+
+	{
+		var n Notification
+		Eventually(descs).Should(Receive(&n))
+		desc := n.Desc
+		Ω(desc.Jid).Should(Equal(jid))
+	}
+
+	Consistently(descs).ShouldNot(Receive())
+}